@@ -0,0 +1,196 @@
+package entcache
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultAsyncWorkers is the default number of workers of the async writer.
+const DefaultAsyncWorkers = 4
+
+// AsyncWrites configures the driver to store the results of cache misses in the
+// background, instead of blocking the caller's rows.Close on the cache write
+// (e.g., a Redis SET). Writes are queued in a bounded queue of the given size,
+// and executed by the given number of workers (DefaultAsyncWorkers, if zero).
+// Writes are dropped when the queue is full, and counted in Stats.Dropped.
+//
+//	drv := entcache.NewDriver(db, entcache.AsyncWrites(1024, 4))
+//	// On shutdown, the queued writes are stored before closing the driver.
+//	defer drv.Close()
+//
+// Note that an entry is not visible in the cache until its write is executed,
+// and hence, identical queries that are executed meanwhile miss the cache. Use
+// Driver.Flush for waiting for the queued writes (e.g., in tests). Queued writes
+// of entries that are invalidated meanwhile (by table invalidation, eviction or
+// InvalidateTags) are skipped.
+func AsyncWrites(queueSize, workers int) Option {
+	return func(o *Options) {
+		o.AsyncQueueSize = queueSize
+		o.AsyncWorkers = workers
+	}
+}
+
+// writer executes the cache writes of the driver in the background.
+type writer struct {
+	queue   chan *write
+	wg      sync.WaitGroup
+	queued  atomic.Uint64
+	dropped atomic.Uint64
+	mu      sync.Mutex
+	closed  bool
+	pending int64
+	// idle is closed when the pending writes are done.
+	idle chan struct{}
+	// writes holds the queued writes by their keys, so they
+	// can be canceled until they are picked up by a worker.
+	writes map[Key][]*write
+}
+
+// write is a queued cache write of an entry.
+type write struct {
+	key      Key
+	tags     []string
+	fn       func()
+	canceled bool
+}
+
+// newWriter returns a writer with the given queue size and number of workers.
+func newWriter(size, workers int) *writer {
+	if workers <= 0 {
+		workers = DefaultAsyncWorkers
+	}
+	w := &writer{queue: make(chan *write, size), writes: make(map[Key][]*write)}
+	w.wg.Add(workers)
+	for range workers {
+		go w.work()
+	}
+	return w
+}
+
+// work executes queued writes until the writer is closed.
+func (w *writer) work() {
+	defer w.wg.Done()
+	for wr := range w.queue {
+		w.mu.Lock()
+		w.remove(wr)
+		canceled := wr.canceled
+		w.mu.Unlock()
+		if !canceled {
+			wr.fn()
+		}
+		w.mu.Lock()
+		if w.pending--; w.pending == 0 {
+			close(w.idle)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// enqueue queues the write of the entry stored under the given key, and reports
+// if it was queued. It returns false without dropping the write if the writer
+// is closed. If the write was queued, index is called (if not nil) before it can
+// be canceled or executed, so invalidations of the keys it indexes cancel it.
+func (w *writer) enqueue(k Key, tags []string, fn, index func()) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return false
+	}
+	wr := &write{key: k, tags: tags, fn: fn}
+	select {
+	case w.queue <- wr:
+		w.writes[k] = append(w.writes[k], wr)
+		w.queued.Add(1)
+		if index != nil {
+			index()
+		}
+		if w.pending++; w.pending == 1 {
+			w.idle = make(chan struct{})
+		}
+	default:
+		w.dropped.Add(1)
+	}
+	return true
+}
+
+// cancel cancels the queued writes of the given keys.
+func (w *writer) cancel(keys ...Key) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, k := range keys {
+		for _, wr := range w.writes[k] {
+			wr.canceled = true
+		}
+	}
+}
+
+// cancelTags cancels the queued writes of entries that
+// are tagged with at least one of the given tags.
+func (w *writer) cancelTags(tags ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, writes := range w.writes {
+		for _, wr := range writes {
+			if slices.ContainsFunc(wr.tags, func(t string) bool { return slices.Contains(tags, t) }) {
+				wr.canceled = true
+			}
+		}
+	}
+}
+
+// remove removes the write from the queued writes, when it is
+// picked up by a worker. The lock must be held.
+func (w *writer) remove(wr *write) {
+	writes := slices.DeleteFunc(w.writes[wr.key], func(o *write) bool { return o == wr })
+	if len(writes) == 0 {
+		delete(w.writes, wr.key)
+	} else {
+		w.writes[wr.key] = writes
+	}
+}
+
+// flush waits for the pending writes to be done.
+func (w *writer) flush(ctx context.Context) error {
+	w.mu.Lock()
+	if w.pending == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	idle := w.idle
+	w.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting writes, and waits for the queued ones to be done.
+func (w *writer) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+}
+
+// size returns the number of pending writes.
+func (w *writer) size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending
+}
+
+// Flush waits for the writes that were queued by the async writer (see
+// AsyncWrites) to be stored in the cache, or for the context to be done.
+func (d *Driver) Flush(ctx context.Context) error {
+	if d.writer == nil {
+		return nil
+	}
+	return d.writer.flush(ctx)
+}
@@ -0,0 +1,181 @@
+package entcache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+// gated is a cache level whose adds block until the gate is opened.
+type gated struct {
+	entcache.AddGetDeleter
+	gate    chan struct{}
+	started chan struct{}
+}
+
+func newGated(l entcache.AddGetDeleter) *gated {
+	return &gated{AddGetDeleter: l, gate: make(chan struct{}), started: make(chan struct{}, 8)}
+}
+
+func (g *gated) Add(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	g.started <- struct{}{}
+	<-g.gate
+	return g.AddGetDeleter.Add(ctx, k, e, ttl)
+}
+
+func (g *gated) Tag(ctx context.Context, k entcache.Key, tags []string, ttl time.Duration) error {
+	return g.AddGetDeleter.(entcache.Tagger).Tag(ctx, k, tags, ttl)
+}
+
+func (g *gated) InvalidateTags(ctx context.Context, tags ...string) error {
+	return g.AddGetDeleter.(entcache.Tagger).InvalidateTags(ctx, tags...)
+}
+
+func TestDriver_AsyncWrites(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lru := entcache.NewLRU(0)
+	level := newGated(lru)
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(level),
+		entcache.AsyncWrites(1, 1),
+		entcache.Hash(func(query string, _ []any) (entcache.Key, error) {
+			return query, nil
+		}),
+	)
+	ctx := entcache.Cache(context.Background())
+	queries := []string{"SELECT name FROM users", "SELECT name FROM pets", "SELECT name FROM groups"}
+	// Adds are blocked, but rows.Close does not wait for them. The first write
+	// is running, the second is queued, and the third is dropped.
+	for i, q := range queries {
+		mock.ExpectQuery(q).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, q, []any{"a8m"})
+		if i == 0 {
+			<-level.started
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Queued != 2 || s.Dropped != 1 || s.Pending != 2 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	fctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := drv.Flush(fctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected flush to time out, got: %v", err)
+	}
+	close(level.gate)
+	if err := drv.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, q := range queries {
+		if _, err := lru.Get(ctx, q); (i < 2) != (err == nil) {
+			t.Fatalf("unexpected entry %q: %v", q, err)
+		}
+	}
+	if s := drv.Stats(); s.Pending != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestDriver_AsyncWritesClose(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lru := entcache.NewLRU(0)
+	level := newGated(lru)
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(level),
+		entcache.AsyncWrites(8, 0),
+		entcache.Hash(func(query string, _ []any) (entcache.Key, error) {
+			return query, nil
+		}),
+	)
+	ctx := entcache.Cache(context.Background())
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	mock.ExpectClose()
+	time.AfterFunc(10*time.Millisecond, func() { close(level.gate) })
+	// Close drains the queued writes.
+	if err := drv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lru.Get(ctx, "SELECT name FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_AsyncWritesInvalidation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lru := entcache.NewLRU(0)
+	level := newGated(entcache.NewLRU(0))
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(level, lru),
+		entcache.AsyncWrites(8, 1),
+		entcache.WithTableInvalidation(true),
+		entcache.Hash(func(query string, _ []any) (entcache.Key, error) {
+			return query, nil
+		}),
+	)
+	ctx := entcache.Cache(context.Background())
+	// The first write blocks the worker, and the others are queued.
+	mock.ExpectQuery("SELECT name FROM pets").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM pets", []any{"a8m"})
+	<-level.started
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	mock.ExpectQuery("SELECT name FROM groups").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Cache(ctx, entcache.WithTags("groups")), t, drv, "SELECT name FROM groups", []any{"a8m"})
+
+	// Invalidations that happen before the queued writes
+	// are executed cancel them.
+	mock.ExpectExec("UPDATE users SET name = ?").
+		WithArgs("a8m").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := drv.Exec(ctx, "UPDATE users SET name = ?", []any{"a8m"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.InvalidateTags(ctx, "groups"); err != nil {
+		t.Fatal(err)
+	}
+	close(level.gate)
+	if err := drv.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lru.Get(ctx, "SELECT name FROM pets"); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{"SELECT name FROM users", "SELECT name FROM groups"} {
+		if _, err := lru.Get(ctx, q); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected invalidated entry %q to be skipped, got: %v", q, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
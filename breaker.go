@@ -0,0 +1,383 @@
+package entcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// Breaker wraps a cache level (commonly a remote one, such as Redis) with a
+	// circuit breaker. When the error rate of the level exceeds a threshold, the
+	// circuit opens, and operations fail immediately without calling the level:
+	// gets are reported as misses, so the driver falls straight through to the
+	// database, and adds and deletions fail with ErrCircuitOpen. After a cooldown
+	// period, the circuit becomes half-open and a single probe operation is passed
+	// to the level. Its success closes the circuit, and its failure opens it again.
+	//
+	//	entcache.NewDriver(
+	//		drv,
+	//		entcache.Levels(
+	//			entcache.NewLRU(256),
+	//			entcache.NewBreaker(
+	//				entcache.NewRedis(rdb),
+	//				entcache.BreakerTimeout(50*time.Millisecond),
+	//			),
+	//		),
+	//	)
+	//
+	// Note that deletions that fail while the circuit is open are not applied to
+	// the level, and hence, it may serve stale entries until they expire.
+	Breaker struct {
+		level         AddGetDeleter
+		timeout       time.Duration
+		threshold     float64
+		minRequests   int
+		window        time.Duration
+		cooldown      time.Duration
+		onStateChange func(from, to BreakerState)
+
+		mu          sync.Mutex
+		state       BreakerState
+		openedAt    time.Time
+		windowStart time.Time
+		requests    int
+		failures    int
+		probing     bool
+	}
+
+	// BreakerOption allows configuring the Breaker
+	// level using functional options.
+	BreakerOption func(*Breaker)
+
+	// BreakerState is the state of the circuit breaker.
+	BreakerState int
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// String implements the fmt.Stringer interface.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrCircuitOpen is returned by the Breaker level when its circuit is open. Gets
+// fail with an error that matches both ErrNotFound and ErrCircuitOpen.
+var ErrCircuitOpen = errors.New("entcache: circuit breaker is open")
+
+// NewBreaker returns a new Breaker level that wraps the given level. By default,
+// the circuit opens when at least half of 10 or more operations fail within 10
+// seconds, and it becomes half-open after 5 seconds.
+func NewBreaker(level AddGetDeleter, opts ...BreakerOption) *Breaker {
+	b := &Breaker{
+		level:       level,
+		threshold:   0.5,
+		minRequests: 10,
+		window:      10 * time.Second,
+		cooldown:    5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// BreakerTimeout configures the timeout of each operation of the wrapped level.
+// Operations that time out are counted as failures, including gets that the level
+// reports as misses. Note that the wrapped level must respect the context deadline
+// (e.g., Redis and Memcache).
+func BreakerTimeout(d time.Duration) BreakerOption {
+	return func(b *Breaker) {
+		b.timeout = d
+	}
+}
+
+// BreakerThreshold configures the error rate (between 0 and 1) that opens the
+// circuit, and the minimum number of operations in the window for applying it.
+func BreakerThreshold(rate float64, minRequests int) BreakerOption {
+	return func(b *Breaker) {
+		b.threshold = rate
+		b.minRequests = minRequests
+	}
+}
+
+// BreakerWindow configures the period in which the operations
+// are counted for calculating the error rate.
+func BreakerWindow(d time.Duration) BreakerOption {
+	return func(b *Breaker) {
+		b.window = d
+	}
+}
+
+// BreakerCooldown configures the period in which the circuit stays
+// open, before it becomes half-open and probes the wrapped level.
+func BreakerCooldown(d time.Duration) BreakerOption {
+	return func(b *Breaker) {
+		b.cooldown = d
+	}
+}
+
+// BreakerOnStateChange configures a callback that is called when the state of
+// the circuit changes (e.g., for logging or metrics). It is called synchronously
+// by the operation that changed the state.
+func BreakerOnStateChange(f func(from, to BreakerState)) BreakerOption {
+	return func(b *Breaker) {
+		b.onStateChange = f
+	}
+}
+
+// State returns the current state of the circuit.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Add adds the entry to the wrapped level.
+func (b *Breaker) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	return b.do(ctx, func(ctx context.Context) error {
+		return b.level.Add(ctx, k, e, ttl)
+	})
+}
+
+// Get gets an entry from the wrapped level.
+func (b *Breaker) Get(ctx context.Context, k Key) (*Entry, error) {
+	var e *Entry
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		e, err = b.level.Get(ctx, k)
+		return err
+	})
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, miss(err)
+	}
+	return e, err
+}
+
+// Del deletes the entry from the wrapped level.
+func (b *Breaker) Del(ctx context.Context, k Key) error {
+	return b.do(ctx, func(ctx context.Context) error {
+		return b.level.Del(ctx, k)
+	})
+}
+
+// GetTTL gets an entry from the wrapped level and its remaining TTL, if the level supports it.
+func (b *Breaker) GetTTL(ctx context.Context, k Key) (*Entry, time.Duration, error) {
+	t, ok := b.level.(TTLGetter)
+	if !ok {
+		e, err := b.Get(ctx, k)
+		return e, -1, err
+	}
+	var (
+		e   *Entry
+		ttl time.Duration
+	)
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		e, ttl, err = t.GetTTL(ctx, k)
+		return err
+	})
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil, 0, miss(err)
+	}
+	return e, ttl, err
+}
+
+// Lease returns a lease token for the given key, if the wrapped level supports leases.
+func (b *Breaker) Lease(ctx context.Context, k Key, ttl time.Duration) (string, error) {
+	l, ok := b.level.(Leaser)
+	if !ok {
+		return "", nil
+	}
+	var token string
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		token, err = l.Lease(ctx, k, ttl)
+		return err
+	})
+	return token, err
+}
+
+// AddLease adds the entry to the wrapped level, using the lease token if it supports leases.
+func (b *Breaker) AddLease(ctx context.Context, k Key, token string, e *Entry, ttl time.Duration) error {
+	l, ok := b.level.(Leaser)
+	if !ok {
+		return b.Add(ctx, k, e, ttl)
+	}
+	return b.do(ctx, func(ctx context.Context) error {
+		return l.AddLease(ctx, k, token, e, ttl)
+	})
+}
+
+// Tag tags the entry in the wrapped level, if it supports tags.
+func (b *Breaker) Tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	t, ok := b.level.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return b.do(ctx, func(ctx context.Context) error {
+		return t.Tag(ctx, k, tags, ttl)
+	})
+}
+
+// InvalidateTags invalidates the given tags in the wrapped level, if it supports tags.
+func (b *Breaker) InvalidateTags(ctx context.Context, tags ...string) error {
+	t, ok := b.level.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return b.do(ctx, func(ctx context.Context) error {
+		return t.InvalidateTags(ctx, tags...)
+	})
+}
+
+// Lock acquires the lock in the wrapped level, if it supports locks.
+func (b *Breaker) Lock(ctx context.Context, k Key, ttl time.Duration) (string, bool, error) {
+	l, ok := b.level.(Locker)
+	if !ok {
+		return "", false, errNoLocker
+	}
+	var (
+		token  string
+		locked bool
+	)
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		token, locked, err = l.Lock(ctx, k, ttl)
+		return err
+	})
+	return token, locked, err
+}
+
+// Unlock releases the lock in the wrapped level, if it supports locks.
+func (b *Breaker) Unlock(ctx context.Context, k Key, token string) error {
+	l, ok := b.level.(Locker)
+	if !ok {
+		return errNoLocker
+	}
+	return b.do(ctx, func(ctx context.Context) error {
+		return l.Unlock(ctx, k, token)
+	})
+}
+
+// Size returns the size of the wrapped level, if it supports it.
+func (b *Breaker) Size() int64 {
+	if s, ok := b.level.(Sizer); ok {
+		return s.Size()
+	}
+	return 0
+}
+
+// do executes the operation if the circuit allows it, and records its result.
+func (b *Breaker) do(ctx context.Context, op func(context.Context) error) error {
+	allowed, probe := b.allow()
+	if !allowed {
+		return ErrCircuitOpen
+	}
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	err := op(ctx)
+	b.record(failed(ctx, err), probe)
+	return err
+}
+
+// failed reports if the error of an operation is counted as a failure. Misses are
+// not failures, unless the operation timed out, or the level reported its error as
+// a miss (e.g., a network error of Redis).
+func failed(ctx context.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded), isUnavailable(err):
+		return true
+	default:
+		return !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrLeaseRevoked) && !errors.Is(err, ErrEntryTooLarge)
+	}
+}
+
+// allow reports if an operation is allowed by the circuit,
+// and if it is the probe operation of the half-open state.
+func (b *Breaker) allow() (allowed, probe bool) {
+	b.mu.Lock()
+	switch b.state {
+	case BreakerClosed:
+		b.mu.Unlock()
+		return true, false
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.mu.Unlock()
+			return false, false
+		}
+		b.probing = true
+		b.transition(BreakerHalfOpen)
+		return true, true
+	default:
+		// Only one probe is allowed in the half-open state.
+		allowed = !b.probing
+		b.probing = true
+		b.mu.Unlock()
+		return allowed, allowed
+	}
+}
+
+// record records the result of an allowed operation. Only the result of the probe
+// changes the half-open state, and the results of operations that were allowed
+// while the circuit was closed are ignored if it was opened in the meantime.
+func (b *Breaker) record(failed, probe bool) {
+	b.mu.Lock()
+	switch {
+	case probe:
+		b.probing = false
+		if failed {
+			b.openedAt = time.Now()
+			b.transition(BreakerOpen)
+		} else {
+			b.requests, b.failures = 0, 0
+			b.windowStart = time.Now()
+			b.transition(BreakerClosed)
+		}
+	case b.state == BreakerClosed:
+		if now := time.Now(); now.Sub(b.windowStart) >= b.window {
+			b.requests, b.failures = 0, 0
+			b.windowStart = now
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.minRequests && float64(b.failures) >= b.threshold*float64(b.requests) {
+			b.openedAt = time.Now()
+			b.transition(BreakerOpen)
+		} else {
+			b.mu.Unlock()
+		}
+	default:
+		// The circuit was opened by a concurrent operation.
+		b.mu.Unlock()
+	}
+}
+
+// transition changes the state of the circuit while the lock is held, releases
+// the lock, and calls the state-change callback outside of it.
+func (b *Breaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+	b.mu.Unlock()
+	if b.onStateChange != nil && from != to {
+		b.onStateChange(from, to)
+	}
+}
@@ -0,0 +1,296 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/rueidis"
+	ruemock "github.com/redis/rueidis/mock"
+	"go.uber.org/mock/gomock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+// slow is a cache level that blocks until the context is done.
+type slow struct{}
+
+func (slow) Add(ctx context.Context, _ entcache.Key, _ *entcache.Entry, _ time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (slow) Get(ctx context.Context, _ entcache.Key) (*entcache.Entry, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (slow) Del(ctx context.Context, _ entcache.Key) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// slowMiss is a cache level that blocks until the context
+// is done, and reports gets that timed out as misses.
+type slowMiss struct{ slow }
+
+func (slowMiss) Get(ctx context.Context, _ entcache.Key) (*entcache.Entry, error) {
+	<-ctx.Done()
+	return nil, entcache.ErrNotFound
+}
+
+// flaky is a cache level that fails all operations while fail is set.
+type flaky struct {
+	entcache.AddGetDeleter
+	fail atomic.Bool
+}
+
+func (f *flaky) Add(ctx context.Context, k entcache.Key, e *entcache.Entry, ttl time.Duration) error {
+	if f.fail.Load() {
+		return errUnavailable
+	}
+	return f.AddGetDeleter.Add(ctx, k, e, ttl)
+}
+
+// blocking is a cache level whose gets block until their key is released.
+type blocking struct {
+	failing
+	started chan entcache.Key
+	release map[entcache.Key]chan struct{}
+}
+
+func (b *blocking) Get(_ context.Context, k entcache.Key) (*entcache.Entry, error) {
+	b.started <- k
+	<-b.release[k]
+	return nil, entcache.ErrNotFound
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+
+	t.Run("States", func(t *testing.T) {
+		var (
+			mu      sync.Mutex
+			changes []string
+		)
+		remote := &failing{}
+		b := entcache.NewBreaker(
+			remote,
+			entcache.BreakerThreshold(0.5, 2),
+			entcache.BreakerCooldown(50*time.Millisecond),
+			entcache.BreakerOnStateChange(func(from, to entcache.BreakerState) {
+				mu.Lock()
+				defer mu.Unlock()
+				changes = append(changes, from.String()+"->"+to.String())
+			}),
+		)
+		for range 2 {
+			if err := b.Add(ctx, 1, e, 0); !errors.Is(err, errUnavailable) {
+				t.Fatalf("expected level error, got: %v", err)
+			}
+		}
+		if s := b.State(); s != entcache.BreakerOpen {
+			t.Fatalf("expected open circuit, got: %v", s)
+		}
+		// Operations fail fast without calling the level.
+		if _, err := b.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) || !errors.Is(err, entcache.ErrCircuitOpen) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if err := b.Del(ctx, 1); !errors.Is(err, entcache.ErrCircuitOpen) {
+			t.Fatalf("expected ErrCircuitOpen, got: %v", err)
+		}
+		if n := remote.calls.Load(); n != 2 {
+			t.Fatalf("expected level to be skipped, got %d calls", n)
+		}
+		// A failed probe opens the circuit again.
+		time.Sleep(60 * time.Millisecond)
+		if err := b.Add(ctx, 1, e, 0); !errors.Is(err, errUnavailable) {
+			t.Fatalf("expected level error, got: %v", err)
+		}
+		if s := b.State(); s != entcache.BreakerOpen {
+			t.Fatalf("expected open circuit, got: %v", s)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if want := []string{"closed->open", "open->half-open", "half-open->open"}; len(changes) != len(want) || changes[0] != want[0] || changes[1] != want[1] || changes[2] != want[2] {
+			t.Fatalf("unexpected state changes: %v", changes)
+		}
+	})
+
+	t.Run("Miss", func(t *testing.T) {
+		b := entcache.NewBreaker(entcache.NewLRU(0), entcache.BreakerThreshold(0.5, 1))
+		// Misses are not counted as failures.
+		if _, err := b.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if s := b.State(); s != entcache.BreakerClosed {
+			t.Fatalf("expected closed circuit, got: %v", s)
+		}
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		lru := entcache.NewLRU(0)
+		l := &flaky{AddGetDeleter: lru}
+		l.fail.Store(true)
+		b := entcache.NewBreaker(l, entcache.BreakerThreshold(0.5, 1), entcache.BreakerCooldown(50*time.Millisecond))
+		_ = b.Add(ctx, 1, e, 0)
+		if s := b.State(); s != entcache.BreakerOpen {
+			t.Fatalf("expected open circuit, got: %v", s)
+		}
+		l.fail.Store(false)
+		time.Sleep(60 * time.Millisecond)
+		if err := b.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if s := b.State(); s != entcache.BreakerClosed {
+			t.Fatalf("expected closed circuit, got: %v", s)
+		}
+		if _, err := b.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		b := entcache.NewBreaker(slow{}, entcache.BreakerTimeout(10*time.Millisecond), entcache.BreakerThreshold(1, 1))
+		start := time.Now()
+		if _, err := b.Get(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected timeout, got: %v", err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("expected operation to time out, took %v", d)
+		}
+		if s := b.State(); s != entcache.BreakerOpen {
+			t.Fatalf("expected open circuit, got: %v", s)
+		}
+	})
+
+	t.Run("Probe", func(t *testing.T) {
+		l := &blocking{
+			started: make(chan entcache.Key),
+			release: map[entcache.Key]chan struct{}{1: make(chan struct{}), 2: make(chan struct{})},
+		}
+		b := entcache.NewBreaker(l, entcache.BreakerThreshold(0.5, 1), entcache.BreakerCooldown(20*time.Millisecond))
+		get := func(k entcache.Key) chan struct{} {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_, _ = b.Get(ctx, k)
+			}()
+			<-l.started
+			return done
+		}
+		// A get that is allowed while the circuit is closed,
+		// and finishes after the circuit became half-open.
+		done := get(1)
+		_ = b.Add(ctx, 1, e, 0)
+		time.Sleep(30 * time.Millisecond)
+		probe := get(2)
+		if s := b.State(); s != entcache.BreakerHalfOpen {
+			t.Fatalf("expected half-open circuit, got: %v", s)
+		}
+		close(l.release[1])
+		<-done
+		if s := b.State(); s != entcache.BreakerHalfOpen {
+			t.Fatalf("expected result of non-probe operation to be ignored, got: %v", s)
+		}
+		close(l.release[2])
+		<-probe
+		if s := b.State(); s != entcache.BreakerClosed {
+			t.Fatalf("expected closed circuit, got: %v", s)
+		}
+	})
+
+	t.Run("RedisError", func(t *testing.T) {
+		rdb := ruemock.NewClient(gomock.NewController(t))
+		rdb.EXPECT().Do(gomock.Any(), ruemock.Match("GET", "1")).
+			Return(ruemock.Result(ruemock.RedisNil()))
+		rdb.EXPECT().Do(gomock.Any(), ruemock.Match("GET", "2")).
+			Return(ruemock.ErrorResult(errors.New("dial tcp: connection refused")))
+		b := entcache.NewBreaker(entcache.NewRedis(rdb), entcache.BreakerThreshold(0.5, 1))
+		// Misses are not counted as failures, but errors that are reported as misses are.
+		if _, err := b.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if s := b.State(); s != entcache.BreakerClosed {
+			t.Fatalf("expected closed circuit, got: %v", s)
+		}
+		if _, err := b.Get(ctx, 2); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if s := b.State(); s != entcache.BreakerOpen {
+			t.Fatalf("expected open circuit, got: %v", s)
+		}
+	})
+
+	t.Run("TimeoutMiss", func(t *testing.T) {
+		// Gets that time out are counted as failures,
+		// even if the level reports them as misses.
+		b := entcache.NewBreaker(slowMiss{}, entcache.BreakerTimeout(10*time.Millisecond), entcache.BreakerThreshold(1, 1))
+		if _, err := b.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if s := b.State(); s != entcache.BreakerOpen {
+			t.Fatalf("expected open circuit, got: %v", s)
+		}
+	})
+
+	t.Run("SlowRedis", func(t *testing.T) {
+		rdb := ruemock.NewClient(gomock.NewController(t))
+		rdb.EXPECT().Do(gomock.Any(), ruemock.Match("GET", "1")).
+			DoAndReturn(func(ctx context.Context, _ rueidis.Completed) rueidis.RedisResult {
+				<-ctx.Done()
+				return ruemock.ErrorResult(ctx.Err())
+			})
+		b := entcache.NewBreaker(entcache.NewRedis(rdb), entcache.BreakerTimeout(10*time.Millisecond), entcache.BreakerThreshold(1, 1))
+		if _, err := b.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected ErrNotFound with its cause, got: %v", err)
+		}
+		if s := b.State(); s != entcache.BreakerOpen {
+			t.Fatalf("expected open circuit, got: %v", s)
+		}
+	})
+}
+
+func TestDriver_Breaker(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &failing{}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(
+			entcache.NewLRU(0),
+			entcache.NewBreaker(remote, entcache.BreakerThreshold(0.5, 1), entcache.BreakerCooldown(time.Minute)),
+		),
+		entcache.Hash(func(string, []any) (entcache.Key, error) {
+			return 1, nil
+		}),
+	)
+	ctx := entcache.Cache(context.Background())
+	// The first get fails, and the query is passed to the database.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	// While the circuit is open, the remote level is treated as a miss.
+	if err := drv.Cache.Del(ctx, 1); !errors.Is(err, entcache.ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got: %v", err)
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if n := remote.calls.Load(); n != 1 {
+		t.Fatalf("expected remote level to be skipped, got %d calls", n)
+	}
+}
@@ -0,0 +1,272 @@
+package entcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+type (
+	// An Invalidation describes the cache entries that were
+	// invalidated by one of the processes sharing a bus.
+	Invalidation struct {
+		Keys []string `json:"k,omitempty"`
+		Tags []string `json:"t,omitempty"`
+	}
+
+	// InvalidationBus defines the interface for broadcasting
+	// invalidations between multiple processes.
+	InvalidationBus interface {
+		// Publish sends the invalidation to all subscribers of the bus.
+		Publish(context.Context, Invalidation) error
+		// Subscribe calls the given function for every invalidation that is
+		// published on the bus (including the ones that were published by the
+		// subscriber itself), until the context is canceled.
+		Subscribe(context.Context, func(Invalidation)) error
+	}
+)
+
+// Broadcast wraps a process-local cache level (e.g., LRU) and shares its
+// invalidations with other processes through an InvalidationBus. Deleting an
+// entry (or invalidating a tag) deletes it from the local level, and publishes
+// it on the bus, so all other processes drop their local copy as well.
+//
+// Keys are converted to their string representation before they are passed to
+// the wrapped level, to ensure that keys received from the bus are identical to
+// the ones that were used for storing the entries.
+//
+//	bus := entcache.NewRedisBus(rdb, "")
+//	local, err := entcache.NewBroadcast(bus, entcache.NewLRU(256))
+//	if err != nil {
+//		return err
+//	}
+//	defer local.Close()
+//	drv := entcache.NewDriver(
+//		db,
+//		entcache.Levels(local, entcache.NewRedis(rdb)),
+//	)
+type Broadcast struct {
+	level  AddGetDeleter
+	bus    InvalidationBus
+	cancel context.CancelFunc
+}
+
+// NewBroadcast returns a new Broadcast level that wraps the given level,
+// and subscribes it to the invalidations published on the given bus.
+func NewBroadcast(bus InvalidationBus, level AddGetDeleter) (*Broadcast, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Broadcast{level: level, bus: bus, cancel: cancel}
+	if err := bus.Subscribe(ctx, b.apply); err != nil {
+		cancel()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Add adds the entry to the local level.
+func (b *Broadcast) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	return b.level.Add(ctx, fmt.Sprint(k), e, ttl)
+}
+
+// Get gets an entry from the local level.
+func (b *Broadcast) Get(ctx context.Context, k Key) (*Entry, error) {
+	return b.level.Get(ctx, fmt.Sprint(k))
+}
+
+// GetTTL gets an entry from the local level and its remaining TTL, if the level supports it.
+func (b *Broadcast) GetTTL(ctx context.Context, k Key) (*Entry, time.Duration, error) {
+	t, ok := b.level.(TTLGetter)
+	if !ok {
+		e, err := b.Get(ctx, k)
+		return e, -1, err
+	}
+	return t.GetTTL(ctx, fmt.Sprint(k))
+}
+
+// Del deletes the entry from the local level, and broadcasts its deletion.
+//
+// In a multi-level cache (see Levels), the deletion is broadcast after the entry
+// was deleted from all levels, as otherwise, other processes may promote the entry
+// of a slower shared level (e.g., Redis) back to their local level before it was
+// deleted. Note that this does not apply if the Broadcast is wrapped by another
+// level (e.g., Level).
+func (b *Broadcast) Del(ctx context.Context, k Key) error {
+	if err := b.del(ctx, k); err != nil {
+		return err
+	}
+	return b.bus.Publish(ctx, Invalidation{Keys: []string{fmt.Sprint(k)}})
+}
+
+// del deletes the entry from the local level without broadcasting its deletion.
+func (b *Broadcast) del(ctx context.Context, k Key) error {
+	return b.level.Del(ctx, fmt.Sprint(k))
+}
+
+// Lease returns a lease token for the given key, if the local level supports leases.
+func (b *Broadcast) Lease(ctx context.Context, k Key, ttl time.Duration) (string, error) {
+	if l, ok := b.level.(Leaser); ok {
+		return l.Lease(ctx, fmt.Sprint(k), ttl)
+	}
+	return "", nil
+}
+
+// AddLease adds the entry to the local level, using the lease token if it supports leases.
+func (b *Broadcast) AddLease(ctx context.Context, k Key, token string, e *Entry, ttl time.Duration) error {
+	if l, ok := b.level.(Leaser); ok {
+		return l.AddLease(ctx, fmt.Sprint(k), token, e, ttl)
+	}
+	return b.level.Add(ctx, fmt.Sprint(k), e, ttl)
+}
+
+// Tag tags the entry in the local level, if it supports tags.
+func (b *Broadcast) Tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	t, ok := b.level.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.Tag(ctx, fmt.Sprint(k), tags, ttl)
+}
+
+// InvalidateTags invalidates the tags in the local level, and broadcasts their
+// invalidation. Similar to Del, in a multi-level cache, the invalidation is
+// broadcast after the tags were invalidated in all levels.
+func (b *Broadcast) InvalidateTags(ctx context.Context, tags ...string) error {
+	if err := b.invalidateTags(ctx, tags...); err != nil {
+		return err
+	}
+	return b.bus.Publish(ctx, Invalidation{Tags: tags})
+}
+
+// invalidateTags invalidates the tags in the local level without broadcasting their invalidation.
+func (b *Broadcast) invalidateTags(ctx context.Context, tags ...string) error {
+	t, ok := b.level.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.InvalidateTags(ctx, tags...)
+}
+
+// Size returns the size of the local level, if it supports it.
+func (b *Broadcast) Size() int64 {
+	if s, ok := b.level.(Sizer); ok {
+		return s.Size()
+	}
+	return 0
+}
+
+// Close unsubscribes the level from the bus.
+func (b *Broadcast) Close() error {
+	b.cancel()
+	return nil
+}
+
+// apply applies an invalidation received from the bus on the local level.
+// Errors are ignored, as there is no caller to report them to.
+func (b *Broadcast) apply(inv Invalidation) {
+	ctx := context.Background()
+	for _, k := range inv.Keys {
+		_ = b.level.Del(ctx, k)
+	}
+	if t, ok := b.level.(Tagger); ok && len(inv.Tags) > 0 {
+		_ = t.InvalidateTags(ctx, inv.Tags...)
+	}
+}
+
+// DefaultBusChannel is the Redis channel used by RedisBus, if no channel was provided.
+const DefaultBusChannel = "entcache:invalidations"
+
+// RedisBus provides an InvalidationBus backed by Redis Pub/Sub.
+//
+// Note that Redis Pub/Sub does not persist messages. Hence, invalidations that
+// are published while a subscriber is disconnected are not delivered to it.
+type RedisBus struct {
+	c       rueidis.Client
+	channel string
+}
+
+// NewRedisBus returns a new RedisBus that publishes the invalidations on the given channel.
+// If the channel is empty, DefaultBusChannel is used.
+func NewRedisBus(c rueidis.Client, channel string) *RedisBus {
+	if channel == "" {
+		channel = DefaultBusChannel
+	}
+	return &RedisBus{c: c, channel: channel}
+}
+
+// Publish publishes the invalidation on the Redis channel.
+func (r *RedisBus) Publish(ctx context.Context, inv Invalidation) error {
+	buf, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return r.c.Do(ctx, r.c.B().Publish().Channel(r.channel).Message(rueidis.BinaryString(buf)).Build()).Error()
+}
+
+// Subscribe subscribes to the Redis channel in the background, and resubscribes
+// in case the connection is lost, until the context is canceled. Malformed messages
+// are ignored.
+func (r *RedisBus) Subscribe(ctx context.Context, f func(Invalidation)) error {
+	go func() {
+		for ctx.Err() == nil {
+			// Receive blocks until the subscription ends or the connection is lost.
+			_ = r.c.Receive(ctx, r.c.B().Subscribe().Channel(r.channel).Build(), func(msg rueidis.PubSubMessage) {
+				var inv Invalidation
+				if err := json.Unmarshal([]byte(msg.Message), &inv); err == nil {
+					f(inv)
+				}
+			})
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	return nil
+}
+
+// MemoryBus provides an in-memory InvalidationBus that delivers invalidations
+// synchronously to all subscribers in the same process. It is mainly useful for
+// tests and for running multiple drivers in one process.
+type MemoryBus struct {
+	mu   sync.RWMutex
+	next int
+	subs map[int]func(Invalidation)
+}
+
+// NewMemoryBus returns a new MemoryBus.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{subs: make(map[int]func(Invalidation))}
+}
+
+// Publish calls all subscribers with the given invalidation.
+func (m *MemoryBus) Publish(_ context.Context, inv Invalidation) error {
+	m.mu.RLock()
+	subs := make([]func(Invalidation), 0, len(m.subs))
+	for _, f := range m.subs {
+		subs = append(subs, f)
+	}
+	m.mu.RUnlock()
+	for _, f := range subs {
+		f(inv)
+	}
+	return nil
+}
+
+// Subscribe registers the given function until the context is canceled.
+func (m *MemoryBus) Subscribe(ctx context.Context, f func(Invalidation)) error {
+	m.mu.Lock()
+	id := m.next
+	m.next++
+	m.subs[id] = f
+	m.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		m.mu.Lock()
+		delete(m.subs, id)
+		m.mu.Unlock()
+	})
+	return nil
+}
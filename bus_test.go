@@ -0,0 +1,206 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/rueidis"
+	ruemock "github.com/redis/rueidis/mock"
+	"go.uber.org/mock/gomock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestBroadcast(t *testing.T) {
+	ctx := context.Background()
+	bus := entcache.NewMemoryBus()
+	replicas := make([]*entcache.Broadcast, 3)
+	for i := range replicas {
+		b, err := entcache.NewBroadcast(bus, entcache.NewLRU(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		replicas[i] = b
+	}
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	for _, r := range replicas {
+		if err := r.Add(ctx, uint64(1), e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Add(ctx, uint64(2), e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Tag(ctx, uint64(2), []string{"user:1"}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := replicas[0].Del(ctx, uint64(1)); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range replicas {
+		if _, err := r.Get(ctx, uint64(1)); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected key to be evicted from replica %d, got: %v", i, err)
+		}
+		if _, err := r.Get(ctx, uint64(2)); err != nil {
+			t.Fatalf("unexpected eviction of key from replica %d: %v", i, err)
+		}
+	}
+
+	if err := replicas[1].InvalidateTags(ctx, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range replicas {
+		if _, err := r.Get(ctx, uint64(2)); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected tagged key to be evicted from replica %d, got: %v", i, err)
+		}
+	}
+}
+
+func TestBroadcast_Promote(t *testing.T) {
+	ctx := context.Background()
+	l1 := entcache.NewLRU(0)
+	b, err := entcache.NewBroadcast(entcache.NewMemoryBus(), entcache.NewLRU(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	drv := entcache.NewDriver(nil, entcache.Levels(l1, b))
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	if err := b.Add(ctx, 1, e, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := drv.Cache.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Hits are promoted with their remaining TTL.
+	if _, ttl, err := l1.GetTTL(ctx, 1); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected promoted entry: %v %v", ttl, err)
+	}
+}
+
+func TestBroadcast_PublishOrder(t *testing.T) {
+	ctx := context.Background()
+	bus := entcache.NewMemoryBus()
+	local, err := entcache.NewBroadcast(bus, entcache.NewLRU(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	shared := entcache.NewLRU(0)
+	drv := entcache.NewDriver(nil, entcache.Levels(local, shared))
+	// Other processes that receive the invalidation must not find
+	// the entry in the shared level, as they would promote it.
+	var stale []entcache.Invalidation
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	err = bus.Subscribe(sctx, func(inv entcache.Invalidation) {
+		if _, err := shared.Get(ctx, "1"); err == nil {
+			stale = append(stale, inv)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	if err := drv.Cache.Add(ctx, "1", e, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.Cache.Del(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.Cache.Add(ctx, "1", e, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.Cache.(entcache.Tagger).Tag(ctx, "1", []string{"user:1"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.InvalidateTags(ctx, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) > 0 {
+		t.Fatalf("invalidations were published before the shared level was invalidated: %v", stale)
+	}
+	for _, l := range []entcache.AddGetDeleter{local, shared} {
+		if _, err := l.Get(ctx, "1"); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected entry to be deleted, got: %v", err)
+		}
+	}
+}
+
+func TestDriver_Broadcast(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		bus    = entcache.NewMemoryBus()
+		shared = entcache.NewLRU(0) // Simulates a remote level.
+		drvs   = make([]*entcache.Driver, 2)
+	)
+	for i := range drvs {
+		local, err := entcache.NewBroadcast(bus, entcache.NewLRU(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer local.Close()
+		drvs[i] = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(local, shared))
+	}
+	ctx := entcache.Cache(context.Background())
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drvs[0], "SELECT name FROM users", []any{"a8m"})
+	expectQuery(ctx, t, drvs[1], "SELECT name FROM users", []any{"a8m"})
+	// Invalidate the entry using the second replica.
+	expectQuery(entcache.Cache(ctx, entcache.CacheOnly(), entcache.Evict()), t, drvs[1], "SELECT name FROM users", []any{})
+
+	// The first replica must not serve its local copy.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("nat"))
+	expectQuery(ctx, t, drvs[0], "SELECT name FROM users", []any{"nat"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisBus(t *testing.T) {
+	var (
+		ctx = context.Background()
+		rdb = ruemock.NewClient(gomock.NewController(t))
+		bus = entcache.NewRedisBus(rdb, "")
+	)
+	rdb.EXPECT().Do(ctx, ruemock.Match("PUBLISH", entcache.DefaultBusChannel, `{"k":["1"]}`)).
+		Return(ruemock.Result(ruemock.RedisInt64(1)))
+	if err := bus.Publish(ctx, entcache.Invalidation{Keys: []string{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan entcache.Invalidation, 1)
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rdb.EXPECT().Receive(gomock.Any(), ruemock.Match("SUBSCRIBE", entcache.DefaultBusChannel), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ rueidis.Completed, f func(rueidis.PubSubMessage)) error {
+			f(rueidis.PubSubMessage{Channel: entcache.DefaultBusChannel, Message: "malformed"})
+			f(rueidis.PubSubMessage{Channel: entcache.DefaultBusChannel, Message: `{"t":["user:1"]}`})
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	if err := bus.Subscribe(subCtx, func(inv entcache.Invalidation) { received <- inv }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case inv := <-received:
+		if len(inv.Tags) != 1 || inv.Tags[0] != "user:1" {
+			t.Fatalf("unexpected invalidation: %+v", inv)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for invalidation")
+	}
+}
@@ -0,0 +1,387 @@
+package entcache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	// Disk provides a persistent cache level backed by an embedded bbolt database,
+	// and implements the AddGetDeleter interface. It allows single-instance services
+	// to keep their cache warm across restarts without running a remote cache.
+	//
+	// Entries are stored with their expiry time, and when the total size of the stored
+	// entries exceeds the configured limit, the oldest entries are evicted. Expired entries
+	// are removed, and the database file is compacted, periodically in the background.
+	Disk struct {
+		path     string
+		maxBytes int64
+		interval time.Duration
+		codec    Codec
+		// mu guards the database handle, which is
+		// replaced when the database is compacted.
+		mu sync.RWMutex
+		db *bolt.DB
+		// wmu serializes write transactions with the updates of
+		// the size, so they are evicted using the committed size.
+		wmu       sync.Mutex
+		size      atomic.Int64
+		done      chan struct{}
+		wg        sync.WaitGroup
+		closeOnce sync.Once
+		closeErr  error
+	}
+
+	// DiskOption allows configuring the Disk
+	// cache level using functional options.
+	DiskOption func(*Disk)
+)
+
+var (
+	// entriesBucket maps keys to their entry records.
+	entriesBucket = []byte("entries")
+	// orderBucket maps write sequence numbers to keys, and is used for
+	// evicting the oldest entries when the size limit is exceeded.
+	orderBucket = []byte("order")
+)
+
+const (
+	// diskHeader is the size of the record header: expiry (8 bytes) and sequence (8 bytes).
+	diskHeader              = 16
+	defaultCompactInterval  = 5 * time.Minute
+	defaultDiskOpenTimeout  = time.Second
+	minCompactFileSizeBytes = 1 << 20
+)
+
+// NewDisk opens (or creates) a Disk cache level stored in the given file.
+//
+//	disk, err := entcache.NewDisk("cache.db", entcache.DiskMaxBytes(512<<20))
+//	if err != nil {
+//		return err
+//	}
+//	defer disk.Close()
+//	drv := entcache.NewDriver(db, entcache.Levels(entcache.NewLRU(256), disk))
+func NewDisk(path string, opts ...DiskOption) (*Disk, error) {
+	d := &Disk{
+		path:     path,
+		interval: defaultCompactInterval,
+		codec:    GobCodec,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	if d.interval > 0 {
+		d.wg.Add(1)
+		go d.janitor()
+	}
+	return d, nil
+}
+
+// DiskMaxBytes limits the total size of the stored entries (keys and
+// serialized values). Zero, the default, means no limit.
+func DiskMaxBytes(n int64) DiskOption {
+	return func(d *Disk) {
+		d.maxBytes = n
+	}
+}
+
+// DiskCompactInterval configures the interval of the background job that
+// removes expired entries and compacts the database file. The default is 5m,
+// and zero disables the background job.
+func DiskCompactInterval(interval time.Duration) DiskOption {
+	return func(d *Disk) {
+		d.interval = interval
+	}
+}
+
+// DiskCodec configures the codec that is used for serializing
+// the entries. The default is GobCodec.
+func DiskCodec(c Codec) DiskOption {
+	return func(d *Disk) {
+		d.codec = c
+	}
+}
+
+// Add adds the entry to the cache.
+func (d *Disk) Add(_ context.Context, k Key, e *Entry, ttl time.Duration) error {
+	key := []byte(fmt.Sprint(k))
+	buf, err := encodeEntry(d.codec, e)
+	if err != nil {
+		return err
+	}
+	if n := int64(len(key) + diskHeader + len(buf)); d.maxBytes > 0 && n > d.maxBytes {
+		return fmt.Errorf("%w: %d > %d bytes", ErrEntryTooLarge, n, d.maxBytes)
+	}
+	var expiry int64
+	if ttl != 0 {
+		expiry = time.Now().Add(ttl).UnixNano()
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.update(func(b *diskBuckets) error {
+		if err := b.delete(key); err != nil {
+			return err
+		}
+		seq, err := b.order.NextSequence()
+		if err != nil {
+			return err
+		}
+		v := make([]byte, diskHeader+len(buf))
+		binary.BigEndian.PutUint64(v, uint64(expiry))
+		binary.BigEndian.PutUint64(v[8:], seq)
+		copy(v[diskHeader:], buf)
+		if err := b.entries.Put(key, v); err != nil {
+			return err
+		}
+		if err := b.order.Put(v[8:diskHeader], key); err != nil {
+			return err
+		}
+		b.delta += int64(len(key) + len(v))
+		if d.maxBytes > 0 {
+			return b.evict(d.maxBytes - d.size.Load())
+		}
+		return nil
+	})
+}
+
+// Get gets an entry from the cache.
+func (d *Disk) Get(ctx context.Context, k Key) (*Entry, error) {
+	e, _, err := d.GetTTL(ctx, k)
+	return e, err
+}
+
+// GetTTL gets an entry from the cache, and its remaining TTL.
+func (d *Disk) GetTTL(_ context.Context, k Key) (*Entry, time.Duration, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var (
+		e   *Entry
+		ttl time.Duration
+	)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(entriesBucket).Get([]byte(fmt.Sprint(k)))
+		now := time.Now()
+		if v == nil || expired(v, now) {
+			return ErrNotFound
+		}
+		if expiry := int64(binary.BigEndian.Uint64(v)); expiry != 0 {
+			ttl = time.Duration(expiry - now.UnixNano())
+		}
+		var err error
+		if e, err = decodeEntry(d.codec, v[diskHeader:]); err != nil {
+			return miss(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return e, ttl, nil
+}
+
+// Del deletes an entry from the cache.
+func (d *Disk) Del(_ context.Context, k Key) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.update(func(b *diskBuckets) error {
+		return b.delete([]byte(fmt.Sprint(k)))
+	})
+}
+
+// Size returns the total size of the stored entries in bytes.
+func (d *Disk) Size() int64 {
+	return d.size.Load()
+}
+
+// Compact removes the expired entries, and compacts the database file
+// if most of its space is not used by entries. Compaction blocks all
+// other operations until it is done.
+func (d *Disk) Compact() error {
+	if err := d.removeExpired(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	if info.Size() < minCompactFileSizeBytes || info.Size() < 2*d.size.Load() {
+		return nil
+	}
+	tmp := d.path + ".compact"
+	dst, err := bolt.Open(tmp, 0o600, &bolt.Options{Timeout: defaultDiskOpenTimeout})
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, d.db, 0); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := d.db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		return errors.Join(err, d.open())
+	}
+	return d.open()
+}
+
+// Close stops the background job and closes the database.
+// Subsequent calls return the result of the first one.
+func (d *Disk) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.wg.Wait()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.closeErr = d.db.Close()
+	})
+	return d.closeErr
+}
+
+// open opens the database, and computes the size of the stored entries.
+func (d *Disk) open() error {
+	db, err := bolt.Open(d.path, 0o600, &bolt.Options{Timeout: defaultDiskOpenTimeout})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		entries, err := tx.CreateBucketIfNotExists(entriesBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(orderBucket); err != nil {
+			return err
+		}
+		var size int64
+		if err := entries.ForEach(func(k, v []byte) error {
+			size += int64(len(k) + len(v))
+			return nil
+		}); err != nil {
+			return err
+		}
+		d.size.Store(size)
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	d.db = db
+	return nil
+}
+
+// janitor compacts the database periodically until the level is closed.
+func (d *Disk) janitor() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			// Errors are ignored, as there is no caller to report
+			// them to, and the job is retried on the next tick.
+			_ = d.Compact()
+		}
+	}
+}
+
+// removeExpired removes all expired entries.
+func (d *Disk) removeExpired() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	now := time.Now()
+	return d.update(func(b *diskBuckets) error {
+		var keys [][]byte
+		if err := b.entries.ForEach(func(k, v []byte) error {
+			if expired(v, now) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// update executes f in a write transaction, and applies the size
+// changes it made to the level after the transaction was committed.
+// The caller must hold the read lock of the level.
+func (d *Disk) update(f func(*diskBuckets) error) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	b := &diskBuckets{}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		b.entries, b.order = tx.Bucket(entriesBucket), tx.Bucket(orderBucket)
+		return f(b)
+	})
+	if err != nil {
+		return err
+	}
+	d.size.Add(b.delta)
+	return nil
+}
+
+// diskBuckets holds the buckets of a write transaction,
+// and the size changes that were made in it.
+type diskBuckets struct {
+	entries, order *bolt.Bucket
+	delta          int64
+}
+
+// delete deletes the entry record and its order record, if exists.
+func (b *diskBuckets) delete(key []byte) error {
+	v := b.entries.Get(key)
+	if v == nil {
+		return nil
+	}
+	b.delta -= int64(len(key) + len(v))
+	if err := b.order.Delete(v[8:diskHeader]); err != nil {
+		return err
+	}
+	return b.entries.Delete(key)
+}
+
+// evict deletes the oldest entries until the size
+// changes made in the transaction fit the given space.
+func (b *diskBuckets) evict(space int64) error {
+	c := b.order.Cursor()
+	for seq, key := c.First(); seq != nil && b.delta > space; seq, key = c.First() {
+		if err := b.delete(append([]byte(nil), key...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expired reports if the entry record is expired.
+func expired(v []byte, now time.Time) bool {
+	expiry := int64(binary.BigEndian.Uint64(v))
+	return expiry != 0 && now.UnixNano() >= expiry
+}
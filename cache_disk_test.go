@@ -0,0 +1,206 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestDisk(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	e := &entcache.Entry{Columns: []string{"id", "name"}, Values: [][]driver.Value{{int64(1), "a8m"}}}
+
+	t.Run("AddGetDel", func(t *testing.T) {
+		d, err := entcache.NewDisk(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		if _, err := d.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if err := d.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Values) != 1 || got.Values[0][1] != "a8m" {
+			t.Fatalf("unexpected entry: %v", got)
+		}
+		if err := d.Del(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if s := d.Size(); s != 0 {
+			t.Fatalf("unexpected size: %d", s)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		d, err := entcache.NewDisk(filepath.Join(t.TempDir(), "cache.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		if err := d.Add(ctx, 1, e, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if _, ttl, err := d.GetTTL(ctx, 1); err != nil || ttl <= 0 || ttl > time.Hour {
+			t.Fatalf("unexpected TTL: %v %v", ttl, err)
+		}
+		if err := d.Add(ctx, 2, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, ttl, err := d.GetTTL(ctx, 2); err != nil || ttl != 0 {
+			t.Fatalf("unexpected TTL: %v %v", ttl, err)
+		}
+	})
+
+	t.Run("Persist", func(t *testing.T) {
+		d, err := entcache.NewDisk(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Add(ctx, 2, e, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		size := d.Size()
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Close is idempotent.
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		d, err = entcache.NewDisk(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		if _, err := d.Get(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+		if s := d.Size(); s != size {
+			t.Fatalf("unexpected size after reopen: %d != %d", s, size)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		d, err := entcache.NewDisk(filepath.Join(t.TempDir(), "cache.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		if err := d.Add(ctx, 1, e, -time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Add(ctx, 2, e, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected expired entry, got: %v", err)
+		}
+		before := d.Size()
+		if err := d.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		if s := d.Size(); s >= before {
+			t.Fatalf("expected expired entries to be removed: %d >= %d", s, before)
+		}
+		if _, err := d.Get(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		d, err := entcache.NewDisk(filepath.Join(t.TempDir(), "cache.db"), entcache.DiskMaxBytes(2048))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		for i := range 20 {
+			if err := d.Add(ctx, i, e, 0); err != nil {
+				t.Fatal(err)
+			}
+			if s := d.Size(); s > 2048 {
+				t.Fatalf("size limit exceeded: %d", s)
+			}
+		}
+		if _, err := d.Get(ctx, 0); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected oldest entry to be evicted, got: %v", err)
+		}
+		if _, err := d.Get(ctx, 19); err != nil {
+			t.Fatal(err)
+		}
+		// Concurrent adds are evicted using the committed size.
+		var wg sync.WaitGroup
+		for i := range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := d.Add(ctx, i, e, 0); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		if s := d.Size(); s > 2048 {
+			t.Fatalf("size limit exceeded: %d", s)
+		}
+		large := &entcache.Entry{Columns: []string{"v"}, Values: [][]driver.Value{{strings.Repeat("v", 4096)}}}
+		if err := d.Add(ctx, "large", large, 0); !errors.Is(err, entcache.ErrEntryTooLarge) {
+			t.Fatalf("expected ErrEntryTooLarge, got: %v", err)
+		}
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.db")
+		d, err := entcache.NewDisk(path, entcache.DiskCompactInterval(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		large := &entcache.Entry{Columns: []string{"v"}, Values: [][]driver.Value{{strings.Repeat("v", 64<<10)}}}
+		for i := range 64 {
+			if err := d.Add(ctx, fmt.Sprint("k", i), large, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := range 63 {
+			if err := d.Del(ctx, fmt.Sprint("k", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		before, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		after, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Size() >= before.Size() {
+			t.Fatalf("expected file to shrink: %d >= %d", after.Size(), before.Size())
+		}
+		if _, err := d.Get(ctx, "k63"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
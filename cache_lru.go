@@ -2,75 +2,348 @@ package entcache
 
 import (
 	"context"
+	"database/sql/driver"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
+	"unsafe"
 
 	"github.com/golang/groupcache/lru"
 )
 
-// LRU provides an LRU cache that implements the AddGetter interface.
-type LRU struct {
-	mu sync.Mutex
-	*lru.Cache
-}
+type (
+	// LRU provides an LRU cache that implements the AddGetter interface.
+	LRU struct {
+		mu sync.Mutex
+		*lru.Cache
+		noCopy bool
+		// memory budget.
+		maxBytes int64
+		bytes    int64
+		// tag index.
+		tags    map[string]map[Key]struct{}
+		keyTags map[Key][]string
+		// outstanding leases, and their number
+		// after the last sweep of expired ones.
+		leases     map[Key]lease
+		leaseID    uint64
+		leaseSwept int
+	}
+
+	// lease is an outstanding lease of a key.
+	lease struct {
+		token  string
+		expiry time.Time
+	}
+
+	// LRUOption allows configuring the LRU
+	// cache using functional options.
+	LRUOption func(*LRU)
+)
 
 // NewLRU creates a new Cache.
 // If maxEntries is zero, the cache has no limit.
-func NewLRU(maxEntries int) *LRU {
-	return &LRU{
+func NewLRU(maxEntries int, opts ...LRUOption) *LRU {
+	l := &LRU{
 		Cache: lru.New(maxEntries),
 	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.Cache.OnEvicted = l.onEvicted
+	return l
+}
+
+// LRUMaxBytes bounds the cache by the approximate memory size of its entries,
+// in addition to the number of entries. When the total size exceeds the given
+// budget, the least recently used entries are evicted. Entries that are larger
+// than the budget are rejected by Add with ErrEntryTooLarge.
+//
+//	entcache.NewLRU(0, entcache.LRUMaxBytes(64<<20))
+func LRUMaxBytes(n int64) LRUOption {
+	return func(l *LRU) {
+		l.maxBytes = n
+	}
+}
+
+// LRUNoCopy configures the cache to store the added entries as-is, instead
+// of storing a copy of them. It avoids the cost of copying large entries, but
+// it is only safe if the entries are not modified after they were added (e.g.
+// by custom cache levels or hooks that hold a reference to them).
+func LRUNoCopy() LRUOption {
+	return func(l *LRU) {
+		l.noCopy = true
+	}
 }
 
 // Add adds the entry to the cache.
 func (l *LRU) Add(_ context.Context, k Key, e *Entry, ttl time.Duration) error {
+	ne := e
+	if !l.noCopy {
+		ne = cloneEntry(e)
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	buf, err := e.MarshalBinary()
-	if err != nil {
-		return err
+	return l.add(k, ne, ttl)
+}
+
+// add adds the entry to the cache while the lock is held.
+func (l *LRU) add(k Key, ne *Entry, ttl time.Duration) error {
+	if l.maxBytes <= 0 {
+		if ttl == 0 {
+			l.Cache.Add(k, ne)
+		} else {
+			l.Cache.Add(k, &entry{Entry: ne, expiry: time.Now().Add(ttl)})
+		}
+		return nil
+	}
+	size := entrySize(ne)
+	if size > l.maxBytes {
+		// The previous result is stale, and should not be served.
+		l.Remove(k)
+		return fmt.Errorf("%w: %d > %d bytes", ErrEntryTooLarge, size, l.maxBytes)
+	}
+	// Replacing an entry does not trigger the OnEvicted callback.
+	if old, ok := l.Cache.Get(k); ok {
+		if old, ok := old.(*entry); ok {
+			l.bytes -= old.size
+		}
 	}
-	ne := &Entry{}
-	if err := ne.UnmarshalBinary(buf); err != nil {
-		return err
+	ce := &entry{Entry: ne, size: size}
+	if ttl != 0 {
+		ce.expiry = time.Now().Add(ttl)
 	}
-	if ttl == 0 {
-		l.Cache.Add(k, ne)
-	} else {
-		l.Cache.Add(k, &entry{Entry: ne, expiry: time.Now().Add(ttl)})
+	l.Cache.Add(k, ce)
+	l.bytes += size
+	for l.bytes > l.maxBytes && l.Len() > 0 {
+		l.RemoveOldest()
 	}
 	return nil
 }
 
 // Get gets an entry from the cache.
-func (l *LRU) Get(_ context.Context, k Key) (*Entry, error) {
+func (l *LRU) Get(ctx context.Context, k Key) (*Entry, error) {
+	e, _, err := l.GetTTL(ctx, k)
+	return e, err
+}
+
+// GetTTL gets an entry from the cache, and its remaining TTL.
+func (l *LRU) GetTTL(_ context.Context, k Key) (*Entry, time.Duration, error) {
 	l.mu.Lock()
+	defer l.mu.Unlock()
 	e, ok := l.Cache.Get(k)
-	l.mu.Unlock()
 	if !ok {
-		return nil, ErrNotFound
+		return nil, 0, ErrNotFound
 	}
 	switch e := e.(type) {
 	case *Entry:
-		return e, nil
+		return e, 0, nil
 	case *entry:
-		if time.Now().Before(e.expiry) {
-			return e.Entry, nil
+		if e.expiry.IsZero() {
+			return e.Entry, 0, nil
+		}
+		if ttl := time.Until(e.expiry); ttl > 0 {
+			return e.Entry, ttl, nil
 		}
-		l.mu.Lock()
 		l.Remove(k)
-		l.mu.Unlock()
-		return nil, ErrNotFound
+		return nil, 0, ErrNotFound
 	default:
-		return nil, fmt.Errorf("entcache: unexpected entry type: %T", e)
+		return nil, 0, fmt.Errorf("entcache: unexpected entry type: %T", e)
 	}
 }
 
-// Del deletes an entry from the cache.
+// Del deletes an entry from the cache, and revokes its outstanding leases.
 func (l *LRU) Del(_ context.Context, k Key) error {
 	l.mu.Lock()
 	l.Remove(k)
+	delete(l.leases, k)
 	l.mu.Unlock()
 	return nil
 }
+
+// Lease returns a lease token for the given key. Concurrent misses of the
+// same key share the same lease, until it is consumed, revoked, or expired.
+// A zero TTL means the lease does not expire.
+func (l *LRU) Lease(_ context.Context, k Key, ttl time.Duration) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ls, ok := l.leases[k]; ok && (ls.expiry.IsZero() || time.Now().Before(ls.expiry)) {
+		return ls.token, nil
+	}
+	if l.leases == nil {
+		l.leases = make(map[Key]lease)
+	}
+	l.leaseID++
+	ls := lease{token: strconv.FormatUint(l.leaseID, 10)}
+	if ttl > 0 {
+		ls.expiry = time.Now().Add(ttl)
+	}
+	l.leases[k] = ls
+	// Leases of queries that failed or whose entries were never added are
+	// swept when their number doubles, so the sweeping cost is amortized.
+	if n := len(l.leases); n >= minLeaseSweep && n >= 2*l.leaseSwept {
+		now := time.Now()
+		for k, ls := range l.leases {
+			if !ls.expiry.IsZero() && !now.Before(ls.expiry) {
+				delete(l.leases, k)
+			}
+		}
+		l.leaseSwept = len(l.leases)
+	}
+	return ls.token, nil
+}
+
+// minLeaseSweep is the minimum number of leases for sweeping the expired ones.
+const minLeaseSweep = 1024
+
+// AddLease adds the entry to the cache if the given lease token is still valid.
+func (l *LRU) AddLease(_ context.Context, k Key, token string, e *Entry, ttl time.Duration) error {
+	ne := e
+	if !l.noCopy {
+		ne = cloneEntry(e)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ls, ok := l.leases[k]
+	if !ok || ls.token != token {
+		return ErrLeaseRevoked
+	}
+	delete(l.leases, k)
+	if !ls.expiry.IsZero() && !time.Now().Before(ls.expiry) {
+		return ErrLeaseRevoked
+	}
+	return l.add(k, ne, ttl)
+}
+
+// Size returns the approximate memory size of the entries in bytes.
+// It is tracked only if the cache is bounded by LRUMaxBytes.
+func (l *LRU) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bytes
+}
+
+// Tag associates the entry stored under the given key with the given tags.
+func (l *LRU) Tag(_ context.Context, k Key, tags []string, _ time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tags == nil {
+		l.tags = make(map[string]map[Key]struct{})
+		l.keyTags = make(map[Key][]string)
+	}
+	for _, t := range tags {
+		if l.tags[t] == nil {
+			l.tags[t] = make(map[Key]struct{})
+		}
+		if _, ok := l.tags[t][k]; !ok {
+			l.tags[t][k] = struct{}{}
+			l.keyTags[k] = append(l.keyTags[k], t)
+		}
+	}
+	return nil
+}
+
+// InvalidateTags deletes all entries that are associated with one of the given tags.
+func (l *LRU) InvalidateTags(_ context.Context, tags ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range tags {
+		for k := range l.tags[t] {
+			l.Remove(k)
+			delete(l.leases, k)
+			// Entries that were tagged but not added (yet)
+			// are not reported by the OnEvicted callback.
+			l.untag(k)
+		}
+	}
+	return nil
+}
+
+// onEvicted is called by the underlying cache (while the lock is
+// held) when an entry is removed, and cleans up its tag index.
+func (l *LRU) onEvicted(k lru.Key, v any) {
+	if e, ok := v.(*entry); ok {
+		l.bytes -= e.size
+	}
+	l.untag(k)
+}
+
+// untag removes the key from the tag index.
+func (l *LRU) untag(k Key) {
+	for _, t := range l.keyTags[k] {
+		delete(l.tags[t], k)
+		if len(l.tags[t]) == 0 {
+			delete(l.tags, t)
+		}
+	}
+	delete(l.keyTags, k)
+}
+
+// Approximate sizes used for estimating the memory size of entries.
+const (
+	sizeofString = int64(unsafe.Sizeof(""))
+	sizeofSlice  = int64(unsafe.Sizeof([]byte(nil)))
+	sizeofValue  = int64(unsafe.Sizeof(driver.Value(nil)))
+	// sizeofEntry accounts for the Entry and its wrapper,
+	// and the list element and map item of the LRU.
+	sizeofEntry = 128
+)
+
+// entrySize returns the approximate memory size of the entry in bytes,
+// including its columns, rows, and the payloads of its values.
+func entrySize(e *Entry) int64 {
+	size := int64(sizeofEntry)
+	for _, c := range e.Columns {
+		size += sizeofString + int64(len(c))
+	}
+	for _, row := range e.Values {
+		size += sizeofSlice + int64(len(row))*sizeofValue
+		for _, v := range row {
+			switch v := v.(type) {
+			case string:
+				size += sizeofString + int64(len(v))
+			case []byte:
+				size += sizeofSlice + int64(len(v))
+			case time.Time:
+				size += int64(unsafe.Sizeof(v))
+			case nil:
+			default:
+				// Numeric and boolean values are boxed.
+				size += 8
+			}
+		}
+	}
+	return size
+}
+
+// cloneEntry returns a deep copy of the entry. Values are copied directly,
+// and only []byte payloads are cloned, as other driver values are immutable.
+func cloneEntry(e *Entry) *Entry {
+	ne := &Entry{
+		Columns:   append([]string(nil), e.Columns...),
+		Values:    make([][]driver.Value, len(e.Values)),
+		StaleAt:   e.StaleAt,
+		CreatedAt: e.CreatedAt,
+		Delta:     e.Delta,
+		Tags:      e.Tags,
+	}
+	var n int
+	for _, row := range e.Values {
+		n += len(row)
+	}
+	// Allocate all rows at once.
+	values := make([]driver.Value, n)
+	for i, row := range e.Values {
+		nr := values[:len(row):len(row)]
+		values = values[len(row):]
+		for j, v := range row {
+			if b, ok := v.([]byte); ok && b != nil {
+				v = append([]byte{}, b...)
+			}
+			nr[j] = v
+		}
+		ne.Values[i] = nr
+	}
+	return ne
+}
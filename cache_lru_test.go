@@ -0,0 +1,220 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestLRU_MaxBytes(t *testing.T) {
+	ctx := context.Background()
+	row := func(n int) *entcache.Entry {
+		return &entcache.Entry{Columns: []string{"v"}, Values: [][]driver.Value{{strings.Repeat("v", n)}}}
+	}
+
+	t.Run("Evict", func(t *testing.T) {
+		l := entcache.NewLRU(0, entcache.LRUMaxBytes(4096))
+		for i := range 8 {
+			if err := l.Add(ctx, i, row(1000), 0); err != nil {
+				t.Fatal(err)
+			}
+			if s := l.Size(); s > 4096 {
+				t.Fatalf("memory budget exceeded: %d", s)
+			}
+		}
+		if _, err := l.Get(ctx, 0); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected least recently used entry to be evicted, got: %v", err)
+		}
+		if _, err := l.Get(ctx, 7); err != nil {
+			t.Fatal(err)
+		}
+		for i := range 8 {
+			if err := l.Del(ctx, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if s := l.Size(); s != 0 {
+			t.Fatalf("unexpected size after deletion: %d", s)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		l := entcache.NewLRU(0, entcache.LRUMaxBytes(1<<20))
+		if err := l.Add(ctx, 1, row(1000), 0); err != nil {
+			t.Fatal(err)
+		}
+		size := l.Size()
+		if err := l.Add(ctx, 1, row(1000), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if s := l.Size(); s != size {
+			t.Fatalf("unexpected size after replace: %d != %d", s, size)
+		}
+		if err := l.Add(ctx, 1, row(10), 0); err != nil {
+			t.Fatal(err)
+		}
+		if s := l.Size(); s >= size {
+			t.Fatalf("expected size to shrink: %d >= %d", s, size)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		l := entcache.NewLRU(0, entcache.LRUMaxBytes(1024))
+		if err := l.Add(ctx, 1, row(10), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Add(ctx, 1, row(2048), 0); !errors.Is(err, entcache.ErrEntryTooLarge) {
+			t.Fatalf("expected ErrEntryTooLarge, got: %v", err)
+		}
+		if _, err := l.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected stale entry to be removed, got: %v", err)
+		}
+		if s := l.Size(); s != 0 {
+			t.Fatalf("unexpected size: %d", s)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		l := entcache.NewLRU(0, entcache.LRUMaxBytes(1<<20))
+		if err := l.Add(ctx, 1, row(10), -time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected expired entry, got: %v", err)
+		}
+		if s := l.Size(); s != 0 {
+			t.Fatalf("unexpected size: %d", s)
+		}
+	})
+}
+
+func TestDriver_Bytes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := entcache.NewLRU(0, entcache.LRUMaxBytes(1<<20))
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(l))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Cache(context.Background()), t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Bytes == 0 || s.Bytes != l.Size() {
+		t.Errorf("unexpected stats: %v", s)
+	}
+
+	// Sizes of multiple levels are reported per level, as they
+	// are measured in different resources (memory and disk).
+	disk, err := entcache.NewDisk(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	l = entcache.NewLRU(0, entcache.LRUMaxBytes(1<<20))
+	drv = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(l, disk))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(entcache.Cache(context.Background()), t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Bytes == 0 || s.Bytes != l.Size() {
+		t.Errorf("unexpected stats: %v", s)
+	}
+	if s := drv.LevelStats(); len(s) != 2 || s[0].Bytes != l.Size() || s[1].Bytes == 0 || s[1].Bytes != disk.Size() {
+		t.Errorf("unexpected level stats: %v", s)
+	}
+}
+
+func TestLRU_Copy(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"id", "data"}, Values: [][]driver.Value{{int64(1), []byte("a8m")}, {int64(2), nil}}}
+
+	t.Run("Copy", func(t *testing.T) {
+		l := entcache.NewLRU(0)
+		if err := l.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		e.Values[0][1].([]byte)[0] = 'A'
+		e.Values[1][0] = int64(3)
+		got, err := l.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got.Values[0][1].([]byte)) != "a8m" || got.Values[1][0] != int64(2) || got.Values[1][1] != nil {
+			t.Fatalf("entry was modified: %v", got.Values)
+		}
+	})
+
+	t.Run("NoCopy", func(t *testing.T) {
+		l := entcache.NewLRU(0, entcache.LRUNoCopy())
+		if err := l.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		got, err := l.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != e {
+			t.Fatal("expected the added entry to be stored as-is")
+		}
+	})
+}
+
+// The benchmarks below compare the copy strategies of LRU.Add on a large
+// entry. BenchmarkLRU_Add/Gob measures the previous strategy, which copied
+// the entry by encoding and decoding it.
+func BenchmarkLRU_Add(b *testing.B) {
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"id", "name", "data"}}
+	for i := range 10_000 {
+		e.Values = append(e.Values, []driver.Value{int64(i), "name", []byte("data")})
+	}
+	b.Run("Gob", func(b *testing.B) {
+		l := entcache.NewLRU(0, entcache.LRUNoCopy())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf, err := e.MarshalBinary()
+			if err != nil {
+				b.Fatal(err)
+			}
+			ne := &entcache.Entry{}
+			if err := ne.UnmarshalBinary(buf); err != nil {
+				b.Fatal(err)
+			}
+			if err := l.Add(ctx, 1, ne, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Clone", func(b *testing.B) {
+		l := entcache.NewLRU(0)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := l.Add(ctx, 1, e, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("NoCopy", func(b *testing.B) {
+		l := entcache.NewLRU(0, entcache.LRUNoCopy())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := l.Add(ctx, 1, e, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package entcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Memcache provides a remote cache backed by memcached, and implements the
+	// AddGetDeleter interface using the memcached text protocol.
+	Memcache struct {
+		addr        string
+		timeout     time.Duration
+		maxItemSize int
+		maxIdle     int
+		codec       Codec
+		dialer      net.Dialer
+		mu          sync.Mutex
+		idle        []*memcacheConn
+	}
+
+	// MemcacheOption allows configuring the Memcache
+	// cache level using functional options.
+	MemcacheOption func(*Memcache)
+
+	// memcacheConn is a connection to the memcached server.
+	memcacheConn struct {
+		net.Conn
+		rw *bufio.ReadWriter
+	}
+)
+
+// Default configuration of the Memcache level.
+const (
+	// DefaultMemcacheItemSize is the default maximum item size of memcached (1MB).
+	DefaultMemcacheItemSize = 1 << 20
+	defaultMemcacheTimeout  = time.Second
+	defaultMemcacheIdle     = 8
+	// maxMemcacheKey is the maximum key length accepted by memcached.
+	maxMemcacheKey = 250
+	// maxMemcacheTTL is the maximum relative expiration time of memcached. Larger
+	// values are interpreted by the server as an absolute Unix timestamp.
+	maxMemcacheTTL = 30 * 24 * time.Hour
+)
+
+// ErrEntryTooLarge is returned by Add when the serialized entry
+// exceeds the maximum item size of the cache level.
+var ErrEntryTooLarge = errors.New("entcache: entry exceeds the maximum item size")
+
+// NewMemcache returns a new Memcache cache level that
+// connects to the memcached server at the given address.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.TTL(time.Minute),
+//		entcache.Levels(
+//			entcache.NewLRU(256),
+//			entcache.NewMemcache("localhost:11211"),
+//		),
+//	)
+func NewMemcache(addr string, opts ...MemcacheOption) *Memcache {
+	m := &Memcache{
+		addr:        addr,
+		timeout:     defaultMemcacheTimeout,
+		maxItemSize: DefaultMemcacheItemSize,
+		maxIdle:     defaultMemcacheIdle,
+		codec:       GobCodec,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// MemcacheTimeout configures the timeout of each operation (including dialing),
+// in case the context does not have an earlier deadline. The default is 1s.
+func MemcacheTimeout(d time.Duration) MemcacheOption {
+	return func(m *Memcache) {
+		m.timeout = d
+	}
+}
+
+// MemcacheMaxItemSize configures the maximum size of serialized entries. Entries
+// that exceed this size are rejected by Add with ErrEntryTooLarge. It should match
+// the item size limit of the server (i.e. the -I flag). The default is 1MB.
+func MemcacheMaxItemSize(n int) MemcacheOption {
+	return func(m *Memcache) {
+		m.maxItemSize = n
+	}
+}
+
+// MemcacheMaxIdleConns configures the maximum number of idle connections
+// that are kept open for reuse. The default is 8.
+func MemcacheMaxIdleConns(n int) MemcacheOption {
+	return func(m *Memcache) {
+		m.maxIdle = n
+	}
+}
+
+// MemcacheCodec configures the codec that is used for
+// serializing the entries. The default is GobCodec.
+func MemcacheCodec(c Codec) MemcacheOption {
+	return func(m *Memcache) {
+		m.codec = c
+	}
+}
+
+// Add adds the entry to the cache.
+func (m *Memcache) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	key := memcacheKey(k)
+	if key == "" {
+		return nil
+	}
+	buf, err := encodeEntry(m.codec, e)
+	if err != nil {
+		return err
+	}
+	if len(buf) > m.maxItemSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrEntryTooLarge, len(buf), m.maxItemSize)
+	}
+	return m.do(ctx, func(c *memcacheConn) error {
+		if _, err := fmt.Fprintf(c.rw, "set %s 0 %d %d\r\n", key, memcacheExpiry(ttl), len(buf)); err != nil {
+			return err
+		}
+		if _, err := c.rw.Write(append(buf, '\r', '\n')); err != nil {
+			return err
+		}
+		return c.expect("STORED")
+	})
+}
+
+// Get gets an entry from the cache.
+func (m *Memcache) Get(ctx context.Context, k Key) (*Entry, error) {
+	key := memcacheKey(k)
+	if key == "" {
+		return nil, ErrNotFound
+	}
+	var buf []byte
+	err := m.do(ctx, func(c *memcacheConn) error {
+		if _, err := fmt.Fprintf(c.rw, "get %s\r\n", key); err != nil {
+			return err
+		}
+		if err := c.rw.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := c.line()
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			// VALUE <key> <flags> <bytes>
+			var (
+				name  string
+				flags uint32
+				size  int
+			)
+			if _, err := fmt.Sscanf(line, "VALUE %s %d %d", &name, &flags, &size); err != nil {
+				return fmt.Errorf("entcache: unexpected memcache response: %q", line)
+			}
+			buf = make([]byte, size+2)
+			if _, err := io.ReadFull(c.rw, buf); err != nil {
+				return err
+			}
+			if !bytes.HasSuffix(buf, []byte("\r\n")) {
+				return errors.New("entcache: malformed memcache value")
+			}
+			buf = buf[:size]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		return nil, ErrNotFound
+	}
+	e, err := decodeEntry(m.codec, buf)
+	if err != nil {
+		return nil, miss(err)
+	}
+	return e, nil
+}
+
+// Del deletes an entry from the cache.
+func (m *Memcache) Del(ctx context.Context, k Key) error {
+	key := memcacheKey(k)
+	if key == "" {
+		return nil
+	}
+	return m.do(ctx, func(c *memcacheConn) error {
+		if _, err := fmt.Fprintf(c.rw, "delete %s\r\n", key); err != nil {
+			return err
+		}
+		return c.expect("DELETED", "NOT_FOUND")
+	})
+}
+
+// Close closes all idle connections.
+func (m *Memcache) Close() error {
+	m.mu.Lock()
+	idle := m.idle
+	m.idle = nil
+	m.mu.Unlock()
+	var errs []error
+	for _, c := range idle {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// do executes f on a pooled connection. Connections that encountered
+// an error are closed, as their state is unknown.
+func (m *Memcache) do(ctx context.Context, f func(*memcacheConn) error) error {
+	c, err := m.conn(ctx)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(m.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.SetDeadline(deadline); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err := f(c); err != nil {
+		// Server errors leave the connection in a valid state.
+		var serr memcacheError
+		if errors.As(err, &serr) {
+			m.release(c)
+		} else {
+			_ = c.Close()
+		}
+		return err
+	}
+	m.release(c)
+	return nil
+}
+
+// conn returns an idle connection, or dials a new one.
+func (m *Memcache) conn(ctx context.Context) (*memcacheConn, error) {
+	m.mu.Lock()
+	if n := len(m.idle); n > 0 {
+		c := m.idle[n-1]
+		m.idle = m.idle[:n-1]
+		m.mu.Unlock()
+		return c, nil
+	}
+	m.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	nc, err := m.dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &memcacheConn{Conn: nc, rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))}, nil
+}
+
+// release returns the connection to the pool.
+func (m *Memcache) release(c *memcacheConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.idle) >= m.maxIdle {
+		_ = c.Close()
+		return
+	}
+	m.idle = append(m.idle, c)
+}
+
+// memcacheError is an error reported by the memcached server.
+type memcacheError string
+
+func (e memcacheError) Error() string {
+	return "entcache: memcache: " + string(e)
+}
+
+// line reads a single response line.
+func (c *memcacheConn) line() (string, error) {
+	line, err := c.rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// expect flushes the buffered command and expects one of the given replies.
+func (c *memcacheConn) expect(replies ...string) error {
+	if err := c.rw.Flush(); err != nil {
+		return err
+	}
+	line, err := c.line()
+	if err != nil {
+		return err
+	}
+	for _, r := range replies {
+		if line == r {
+			return nil
+		}
+	}
+	return memcacheError(line)
+}
+
+// memcacheKey returns the memcached key of the given cache key. Keys that are too
+// long or contain whitespace or control characters are replaced with their hash.
+func memcacheKey(k Key) string {
+	key := fmt.Sprint(k)
+	if len(key) > maxMemcacheKey {
+		return hashKey(key)
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return hashKey(key)
+		}
+	}
+	return key
+}
+
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return "entcache:" + hex.EncodeToString(h[:])
+}
+
+// memcacheExpiry converts the TTL to memcached expiration time.
+func memcacheExpiry(ttl time.Duration) int64 {
+	switch {
+	case ttl == 0:
+		return 0
+	case ttl < 0:
+		// Negative values expire the item immediately.
+		return -1
+	case ttl > maxMemcacheTTL:
+		return time.Now().Add(ttl).Unix()
+	default:
+		// Round up, as zero means no expiration.
+		return int64((ttl + time.Second - 1) / time.Second)
+	}
+}
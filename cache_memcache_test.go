@@ -0,0 +1,285 @@
+package entcache_test
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestMemcache(t *testing.T) {
+	srv := newFakeMemcached(t)
+	m := entcache.NewMemcache(srv.addr())
+	defer m.Close()
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"id", "name"}, Values: [][]driver.Value{{int64(1), "a8m"}, {int64(2), []byte("nat")}}}
+
+	t.Run("AddGetDel", func(t *testing.T) {
+		if _, err := m.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+		if err := m.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		got, err := m.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Values) != 2 || got.Values[0][1] != "a8m" || string(got.Values[1][1].([]byte)) != "nat" {
+			t.Fatalf("unexpected entry: %v", got)
+		}
+		if err := m.Del(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Del(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		if err := m.Add(ctx, 2, e, 1500*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if exp := srv.expiry("2"); exp != 2 {
+			t.Fatalf("unexpected expiration time: %d", exp)
+		}
+		if err := m.Add(ctx, 3, e, 60*24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if exp := srv.expiry("3"); exp < time.Now().Unix() {
+			t.Fatalf("expected absolute expiration time, got: %d", exp)
+		}
+		srv.advance(3 * time.Second)
+		if _, err := m.Get(ctx, 2); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected expired entry, got: %v", err)
+		}
+		if _, err := m.Get(ctx, 3); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Key", func(t *testing.T) {
+		keys := []entcache.Key{"with space", strings.Repeat("k", 300)}
+		for _, k := range keys {
+			if err := m.Add(ctx, k, e, 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.Get(ctx, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		m := entcache.NewMemcache(srv.addr(), entcache.MemcacheMaxItemSize(64))
+		defer m.Close()
+		large := &entcache.Entry{Columns: []string{"v"}, Values: [][]driver.Value{{strings.Repeat("v", 128)}}}
+		if err := m.Add(ctx, 4, large, 0); !errors.Is(err, entcache.ErrEntryTooLarge) {
+			t.Fatalf("expected ErrEntryTooLarge, got: %v", err)
+		}
+		if _, err := m.Get(ctx, 4); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		srv.fail("SERVER_ERROR out of memory storing object")
+		defer srv.fail("")
+		if err := m.Add(ctx, 5, e, 0); err == nil || !strings.Contains(err.Error(), "out of memory") {
+			t.Fatalf("expected server error, got: %v", err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				k := fmt.Sprint("concurrent-", i)
+				if err := m.Add(ctx, k, e, 0); err != nil {
+					t.Error(err)
+				}
+				if _, err := m.Get(ctx, k); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestDriver_Memcache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newFakeMemcached(t)
+	m := entcache.NewMemcache(srv.addr())
+	defer m.Close()
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.TTL(time.Minute), entcache.Levels(m))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	ctx := entcache.Cache(context.Background())
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Hits != 1 {
+		t.Errorf("unexpected stats: %v", s)
+	}
+}
+
+// fakeMemcached is an in-process memcached server that supports
+// the set, get and delete commands of the text protocol.
+type fakeMemcached struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	items  map[string]fakeItem
+	offset time.Duration
+	reply  string
+}
+
+type fakeItem struct {
+	value  []byte
+	flags  string
+	exp    int64
+	expiry time.Time
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeMemcached{ln: ln, items: make(map[string]fakeItem)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeMemcached) addr() string {
+	return s.ln.Addr().String()
+}
+
+// advance moves the clock of the server forward.
+func (s *fakeMemcached) advance(d time.Duration) {
+	s.mu.Lock()
+	s.offset += d
+	s.mu.Unlock()
+}
+
+// fail configures the reply of the following set commands.
+func (s *fakeMemcached) fail(reply string) {
+	s.mu.Lock()
+	s.reply = reply
+	s.mu.Unlock()
+}
+
+// expiry returns the expiration time that was sent for the given key.
+func (s *fakeMemcached) expiry(key string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items[key].exp
+}
+
+func (s *fakeMemcached) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (s *fakeMemcached) serve(c net.Conn) {
+	defer c.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) < 2 {
+			fmt.Fprint(rw, "ERROR\r\n")
+			rw.Flush()
+			continue
+		}
+		s.mu.Lock()
+		switch args[0] {
+		case "set":
+			var (
+				exp  int64
+				size int
+			)
+			fmt.Sscan(args[3], &exp)
+			fmt.Sscan(args[4], &size)
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, buf); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			if s.reply != "" {
+				fmt.Fprintf(rw, "%s\r\n", s.reply)
+				break
+			}
+			item := fakeItem{value: buf[:size], flags: args[2], exp: exp}
+			switch {
+			case exp < 0:
+				item.expiry = s.now()
+			case exp > int64(30*24*time.Hour/time.Second):
+				item.expiry = time.Unix(exp, 0)
+			case exp > 0:
+				item.expiry = s.now().Add(time.Duration(exp) * time.Second)
+			}
+			s.items[args[1]] = item
+			fmt.Fprint(rw, "STORED\r\n")
+		case "get":
+			for _, k := range args[1:] {
+				item, ok := s.items[k]
+				if ok && !item.expiry.IsZero() && !s.now().Before(item.expiry) {
+					delete(s.items, k)
+					ok = false
+				}
+				if ok {
+					fmt.Fprintf(rw, "VALUE %s %s %d\r\n%s\r\n", k, item.flags, len(item.value), item.value)
+				}
+			}
+			fmt.Fprint(rw, "END\r\n")
+		case "delete":
+			if _, ok := s.items[args[1]]; ok {
+				delete(s.items, args[1])
+				fmt.Fprint(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		s.mu.Unlock()
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
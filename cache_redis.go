@@ -2,21 +2,62 @@ package entcache
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/rueidis"
 )
 
-// Redis provides a remote cache backed by Redis
-// and implements the SetGetter interface.
-type Redis struct {
-	c rueidis.Client
-}
+type (
+	// Redis provides a remote cache backed by Redis
+	// and implements the SetGetter interface.
+	Redis struct {
+		c rueidis.Client
+		// local TTL of client-side cached reads.
+		// Zero means client-side caching is disabled.
+		clientTTL time.Duration
+		codec     Codec
+	}
+
+	// RedisOption allows configuring the Redis
+	// cache level using functional options.
+	RedisOption func(*Redis)
+)
 
 // NewRedis returns a new Redis cache level from the given Redis connection.
-func NewRedis(c rueidis.Client) *Redis {
-	return &Redis{c: c}
+func NewRedis(c rueidis.Client, opts ...RedisOption) *Redis {
+	r := &Redis{c: c, codec: GobCodec}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// RedisClientCache configures the Redis level to serve reads using the RESP3
+// server-assisted client-side caching of rueidis (i.e. DoCache). Entries are
+// kept in the process memory for up to the given TTL, and Redis invalidates
+// them automatically when they are modified or deleted by any client. Hence,
+// it provides an in-process level that is kept coherent with Redis, instead of
+// a separately managed LRU level that can go stale.
+//
+// Note that the rueidis.Client must be created with client-side caching enabled
+// (i.e. DisableCache is false), and the size of its local cache is configured by
+// rueidis.ClientOption.CacheSizeEachConn.
+func RedisClientCache(ttl time.Duration) RedisOption {
+	return func(r *Redis) {
+		r.clientTTL = ttl
+	}
+}
+
+// RedisCodec configures the codec that is used for
+// serializing the entries. The default is GobCodec.
+func RedisCodec(c Codec) RedisOption {
+	return func(r *Redis) {
+		r.codec = c
+	}
 }
 
 // Add adds the entry to the cache.
@@ -25,7 +66,7 @@ func (r *Redis) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) err
 	if key == "" {
 		return nil
 	}
-	buf, err := e.MarshalBinary()
+	buf, err := encodeEntry(r.codec, e)
 	if err != nil {
 		return err
 	}
@@ -38,22 +79,233 @@ func (r *Redis) Get(ctx context.Context, k Key) (*Entry, error) {
 	if key == "" {
 		return nil, ErrNotFound
 	}
-	buf, err := r.c.Do(ctx, r.c.B().Get().Key(key).Build()).AsBytes()
-	if err != nil || len(buf) == 0 {
+	var resp rueidis.RedisResult
+	if r.clientTTL > 0 {
+		resp = r.c.DoCache(ctx, r.c.B().Get().Key(key).Cache(), r.clientTTL)
+	} else {
+		resp = r.c.Do(ctx, r.c.B().Get().Key(key).Build())
+	}
+	buf, err := resp.AsBytes()
+	switch {
+	case rueidis.IsRedisNil(err) || err == nil && len(buf) == 0:
 		return nil, ErrNotFound
+	case err != nil:
+		// Errors are reported as misses, but are counted as
+		// failures by the Breaker and by fault tolerance.
+		return nil, unavailable(err)
 	}
-	e := &Entry{}
-	if err := e.UnmarshalBinary(buf); err != nil {
-		return nil, err
+	e, err := decodeEntry(r.codec, buf)
+	if err != nil {
+		return nil, miss(err)
 	}
 	return e, nil
 }
 
-// Del deletes an entry from the cache.
+// GetTTL gets an entry from the cache, and its remaining TTL. The TTL is fetched
+// in the same round trip using PTTL, or if client-side caching is enabled, the
+// TTL of the locally cached entry is used, which does not exceed its TTL in Redis.
+func (r *Redis) GetTTL(ctx context.Context, k Key) (*Entry, time.Duration, error) {
+	key := fmt.Sprint(k)
+	if key == "" {
+		return nil, 0, ErrNotFound
+	}
+	var (
+		resp rueidis.RedisResult
+		ttl  time.Duration
+	)
+	if r.clientTTL > 0 {
+		resp = r.c.DoCache(ctx, r.c.B().Get().Key(key).Cache(), r.clientTTL)
+		// A negative value means the TTL is unknown.
+		ttl = time.Duration(resp.CachePTTL()) * time.Millisecond
+	} else {
+		resps := r.c.DoMulti(ctx, r.c.B().Get().Key(key).Build(), r.c.B().Pttl().Key(key).Build())
+		resp = resps[0]
+		switch ms, err := resps[1].AsInt64(); {
+		case err != nil:
+			// Errors are reported as misses, as by Get.
+			return nil, 0, unavailable(err)
+		case ms == -1:
+			// The key has no expiry.
+		case ms <= 0:
+			// The key was expired or deleted.
+			return nil, 0, ErrNotFound
+		default:
+			ttl = time.Duration(ms) * time.Millisecond
+		}
+	}
+	buf, err := resp.AsBytes()
+	switch {
+	case rueidis.IsRedisNil(err) || err == nil && len(buf) == 0:
+		return nil, 0, ErrNotFound
+	case err != nil:
+		return nil, 0, unavailable(err)
+	}
+	e, err := decodeEntry(r.codec, buf)
+	if err != nil {
+		return nil, 0, miss(err)
+	}
+	return e, ttl, nil
+}
+
+// Del deletes an entry from the cache, and revokes its outstanding leases.
 func (r *Redis) Del(ctx context.Context, k Key) error {
 	key := fmt.Sprint(k)
 	if key == "" {
 		return nil
 	}
-	return r.c.Do(ctx, r.c.B().Del().Key(key).Build()).Error()
+	return r.c.Do(ctx, r.c.B().Del().Key(key, leaseKey(key)).Build()).Error()
+}
+
+// leaseKey returns the key of the Redis string that holds the lease of the given
+// key. The key is used as its hash tag, so both are stored in the same cluster slot.
+func leaseKey(key string) string {
+	return "entcache:lease:{" + key + "}"
+}
+
+var (
+	// leaseScript returns the outstanding lease of the key, or creates a new one.
+	leaseScript = rueidis.NewLuaScriptNoSha(`local t = redis.call("GET", KEYS[1])
+if t then return t end
+if ARGV[2] == "0" then redis.call("SET", KEYS[1], ARGV[1]) else redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2]) end
+return ARGV[1]`)
+	// addLeaseScript consumes the lease and stores the entry, only if the lease is still valid.
+	addLeaseScript = rueidis.NewLuaScriptNoSha(`if redis.call("GET", KEYS[1]) ~= ARGV[1] then return 0 end
+redis.call("DEL", KEYS[1])
+if ARGV[3] == "0" then redis.call("SET", KEYS[2], ARGV[2]) else redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3]) end
+return 1`)
+)
+
+// Lease returns a lease token for the given key. Concurrent misses of the same
+// key, in all processes, share the same lease until it is consumed, revoked,
+// or expired. A zero TTL means the lease does not expire.
+func (r *Redis) Lease(ctx context.Context, k Key, ttl time.Duration) (string, error) {
+	key := fmt.Sprint(k)
+	if key == "" {
+		return "", nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return leaseScript.Exec(ctx, r.c, []string{leaseKey(key)}, []string{hex.EncodeToString(b), strconv.FormatInt(ttl.Milliseconds(), 10)}).ToString()
+}
+
+// AddLease adds the entry to the cache if the given lease token is still valid.
+// The lease is checked and consumed atomically with storing the entry.
+func (r *Redis) AddLease(ctx context.Context, k Key, token string, e *Entry, ttl time.Duration) error {
+	key := fmt.Sprint(k)
+	if key == "" {
+		return nil
+	}
+	buf, err := encodeEntry(r.codec, e)
+	if err != nil {
+		return err
+	}
+	args := []string{token, rueidis.BinaryString(buf), strconv.FormatInt(ttl.Milliseconds(), 10)}
+	ok, err := addLeaseScript.Exec(ctx, r.c, []string{leaseKey(key), key}, args).AsBool()
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return ErrLeaseRevoked
+	}
+	return nil
+}
+
+// tagPrefix is the key prefix of the Redis sets that hold the keys of each tag.
+const tagPrefix = "entcache:tag:"
+
+// Tag associates the entry stored under the given key with the given tags.
+// Each tag is stored as a Redis set, so that its index is shared between
+// all processes that use the same Redis database. The expiry of the set
+// is extended to cover the TTL of its entries.
+func (r *Redis) Tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	key := fmt.Sprint(k)
+	if key == "" || ttl < 0 {
+		return nil
+	}
+	cmds := make(rueidis.Commands, 0, len(tags)*3)
+	for _, t := range tags {
+		tag := tagPrefix + t
+		cmds = append(cmds, r.c.B().Sadd().Key(tag).Member(key).Build())
+		if ttl == 0 {
+			cmds = append(cmds, r.c.B().Persist().Key(tag).Build())
+		} else {
+			secs := int64(ttl.Seconds() + 1)
+			cmds = append(cmds,
+				r.c.B().Expire().Key(tag).Seconds(secs).Nx().Build(),
+				r.c.B().Expire().Key(tag).Seconds(secs).Gt().Build(),
+			)
+		}
+	}
+	for _, resp := range r.c.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateTags deletes all entries that are associated with one of the given tags.
+// Keys that are added to a tag concurrently are kept in its set.
+func (r *Redis) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, t := range tags {
+		tag := tagPrefix + t
+		keys, err := r.c.Do(ctx, r.c.B().Smembers().Key(tag).Build()).AsStrSlice()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		cmds := make(rueidis.Commands, 0, len(keys)+1)
+		for _, key := range keys {
+			cmds = append(cmds, r.c.B().Del().Key(key, leaseKey(key)).Build())
+		}
+		cmds = append(cmds, r.c.B().Srem().Key(tag).Member(keys...).Build())
+		for _, resp := range r.c.DoMulti(ctx, cmds...) {
+			if err := resp.Error(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lockPrefix is the key prefix of the Redis keys that hold the locks of entries.
+const lockPrefix = "entcache:lock:"
+
+// unlockScript deletes the lock only if it is still held by the given token.
+var unlockScript = rueidis.NewLuaScriptNoSha(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+// Lock tries to acquire the lock of the given key using SET NX, with a random
+// token and the given expiry. Hence, a lock that was not released by its holder
+// (e.g., the process crashed) expires automatically.
+func (r *Redis) Lock(ctx context.Context, k Key, ttl time.Duration) (string, bool, error) {
+	key := fmt.Sprint(k)
+	if key == "" {
+		return "", false, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(b)
+	err := r.c.Do(ctx, r.c.B().Set().Key(lockPrefix+key).Value(token).Nx().Px(ttl).Build()).Error()
+	switch {
+	case rueidis.IsRedisNil(err):
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// Unlock releases the lock of the given key, if it is still held by the given token.
+func (r *Redis) Unlock(ctx context.Context, k Key, token string) error {
+	key := fmt.Sprint(k)
+	if key == "" {
+		return nil
+	}
+	return unlockScript.Exec(ctx, r.c, []string{lockPrefix + key}, []string{token}).Error()
 }
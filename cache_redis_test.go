@@ -0,0 +1,75 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/rueidis"
+	ruemock "github.com/redis/rueidis/mock"
+	"go.uber.org/mock/gomock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestRedis_ClientCache(t *testing.T) {
+	var (
+		ctx = context.Background()
+		rdb = ruemock.NewClient(gomock.NewController(t))
+		r   = entcache.NewRedis(rdb, entcache.RedisClientCache(time.Minute))
+	)
+	rdb.EXPECT().DoCache(ctx, ruemock.Match("GET", "1"), time.Minute).Return(ruemock.Result(ruemock.RedisNil()))
+	if _, err := r.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	e := entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	buf, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb.EXPECT().DoCache(ctx, ruemock.Match("GET", "1"), time.Minute).
+		Return(ruemock.Result(ruemock.RedisString(rueidis.BinaryString(buf))))
+	got, err := r.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Values) != 1 || got.Values[0][0] != "a8m" {
+		t.Fatalf("unexpected entry: %v", got)
+	}
+}
+
+func TestRedis_GetTTL(t *testing.T) {
+	var (
+		ctx = context.Background()
+		rdb = ruemock.NewClient(gomock.NewController(t))
+		r   = entcache.NewRedis(rdb)
+	)
+	// Errors are reported as misses, as by Get.
+	unavailable := errors.New("connection refused")
+	rdb.EXPECT().DoMulti(ctx, ruemock.Match("GET", "1"), ruemock.Match("PTTL", "1")).Return([]rueidis.RedisResult{
+		ruemock.ErrorResult(unavailable),
+		ruemock.ErrorResult(unavailable),
+	})
+	if _, _, err := r.GetTTL(ctx, 1); !errors.Is(err, entcache.ErrNotFound) || !errors.Is(err, unavailable) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+	rdb.EXPECT().DoMulti(ctx, ruemock.Match("GET", "1"), ruemock.Match("PTTL", "1")).Return([]rueidis.RedisResult{
+		ruemock.ErrorResult(unavailable),
+		ruemock.Result(ruemock.RedisInt64(-1)),
+	})
+	if _, _, err := r.GetTTL(ctx, 1); !errors.Is(err, entcache.ErrNotFound) || !errors.Is(err, unavailable) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+	// The multi-level cache falls through to the database.
+	rdb.EXPECT().DoMulti(ctx, ruemock.Match("GET", "1"), ruemock.Match("PTTL", "1")).Return([]rueidis.RedisResult{
+		ruemock.ErrorResult(unavailable),
+		ruemock.ErrorResult(unavailable),
+	})
+	drv := entcache.NewDriver(nil, entcache.Levels(entcache.NewLRU(0), r))
+	if _, err := drv.Cache.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package entcache
+
+import (
+	"context"
+	"hash/maphash"
+	"time"
+)
+
+// ShardedLRU provides an in-process cache that spreads its entries over
+// multiple LRU shards by the hash of their keys. Each shard has its own lock
+// and eviction, which reduces lock contention under concurrent access. It
+// implements the AddGetDeleter interface, and can be used in place of LRU.
+type ShardedLRU struct {
+	seed   maphash.Seed
+	shards []*LRU
+}
+
+// DefaultShards is the default number of shards of the ShardedLRU.
+const DefaultShards = 16
+
+// NewShardedLRU creates a new ShardedLRU with the given number of shards. The
+// limits (maxEntries, and LRUMaxBytes if configured) are divided evenly between
+// the shards, and therefore, eviction is approximately LRU across the cache.
+// If shards is zero, DefaultShards is used. If maxEntries is zero, the cache
+// has no limit.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(entcache.NewShardedLRU(0, 4096)),
+//	)
+func NewShardedLRU(shards, maxEntries int, opts ...LRUOption) *ShardedLRU {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	s := &ShardedLRU{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*LRU, shards),
+	}
+	if maxEntries > 0 {
+		maxEntries = (maxEntries + shards - 1) / shards
+	}
+	for i := range s.shards {
+		l := NewLRU(maxEntries, opts...)
+		if l.maxBytes > 0 {
+			l.maxBytes = (l.maxBytes + int64(shards) - 1) / int64(shards)
+		}
+		s.shards[i] = l
+	}
+	return s
+}
+
+// Add adds the entry to the cache.
+func (s *ShardedLRU) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	return s.shard(k).Add(ctx, k, e, ttl)
+}
+
+// Get gets an entry from the cache.
+func (s *ShardedLRU) Get(ctx context.Context, k Key) (*Entry, error) {
+	return s.shard(k).Get(ctx, k)
+}
+
+// GetTTL gets an entry from the cache, and its remaining TTL.
+func (s *ShardedLRU) GetTTL(ctx context.Context, k Key) (*Entry, time.Duration, error) {
+	return s.shard(k).GetTTL(ctx, k)
+}
+
+// Del deletes an entry from the cache.
+func (s *ShardedLRU) Del(ctx context.Context, k Key) error {
+	return s.shard(k).Del(ctx, k)
+}
+
+// Lease returns a lease token for the given key.
+func (s *ShardedLRU) Lease(ctx context.Context, k Key, ttl time.Duration) (string, error) {
+	return s.shard(k).Lease(ctx, k, ttl)
+}
+
+// AddLease adds the entry to the cache if the given lease token is still valid.
+func (s *ShardedLRU) AddLease(ctx context.Context, k Key, token string, e *Entry, ttl time.Duration) error {
+	return s.shard(k).AddLease(ctx, k, token, e, ttl)
+}
+
+// Tag associates the entry stored under the given key with the given tags.
+func (s *ShardedLRU) Tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	return s.shard(k).Tag(ctx, k, tags, ttl)
+}
+
+// InvalidateTags deletes all entries that are associated with one of the given tags.
+func (s *ShardedLRU) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, l := range s.shards {
+		if err := l.InvalidateTags(ctx, tags...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries in the cache.
+func (s *ShardedLRU) Len() int {
+	var n int
+	for _, l := range s.shards {
+		l.mu.Lock()
+		n += l.Cache.Len()
+		l.mu.Unlock()
+	}
+	return n
+}
+
+// Size returns the approximate memory size of the entries in bytes.
+// It is tracked only if the cache is bounded by LRUMaxBytes.
+func (s *ShardedLRU) Size() int64 {
+	var size int64
+	for _, l := range s.shards {
+		size += l.Size()
+	}
+	return size
+}
+
+// shard returns the shard of the given key.
+func (s *ShardedLRU) shard(k Key) *LRU {
+	var h uint64
+	switch k := k.(type) {
+	case uint64:
+		// Keys generated by DefaultHash are already uniformly distributed.
+		h = k
+	case string:
+		h = maphash.String(s.seed, k)
+	default:
+		h = maphash.Comparable(s.seed, k)
+	}
+	return s.shards[h%uint64(len(s.shards))]
+}
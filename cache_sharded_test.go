@@ -0,0 +1,220 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestShardedLRU(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+
+	t.Run("AddGetDel", func(t *testing.T) {
+		s := entcache.NewShardedLRU(4, 0)
+		keys := []entcache.Key{uint64(1), "key", 42}
+		for _, k := range keys {
+			if err := s.Add(ctx, k, e, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := s.Len(); n != len(keys) {
+			t.Fatalf("unexpected length: %d", n)
+		}
+		for _, k := range keys {
+			got, err := s.Get(ctx, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Values[0][0] != "a8m" {
+				t.Fatalf("unexpected entry: %v", got)
+			}
+			if err := s.Del(ctx, k); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Get(ctx, k); !errors.Is(err, entcache.ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got: %v", err)
+			}
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		s := entcache.NewShardedLRU(4, 0)
+		if err := s.Add(ctx, 1, e, -time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected expired entry, got: %v", err)
+		}
+	})
+
+	t.Run("MaxEntries", func(t *testing.T) {
+		s := entcache.NewShardedLRU(4, 64)
+		for i := range 1000 {
+			if err := s.Add(ctx, uint64(i), e, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := s.Len(); n > 64 {
+			t.Fatalf("entries limit exceeded: %d", n)
+		}
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		s := entcache.NewShardedLRU(4, 0, entcache.LRUMaxBytes(16<<10))
+		large := &entcache.Entry{Columns: []string{"v"}, Values: [][]driver.Value{{strings.Repeat("v", 1000)}}}
+		for i := range 100 {
+			if err := s.Add(ctx, uint64(i), large, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if size := s.Size(); size == 0 || size > 16<<10 {
+			t.Fatalf("unexpected size: %d", size)
+		}
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		s := entcache.NewShardedLRU(4, 0)
+		for i := range 8 {
+			if err := s.Tag(ctx, i, []string{"users"}, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Add(ctx, i, e, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.InvalidateTags(ctx, "users"); err != nil {
+			t.Fatal(err)
+		}
+		if n := s.Len(); n != 0 {
+			t.Fatalf("expected all entries to be invalidated, got: %d", n)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		s := entcache.NewShardedLRU(0, 128)
+		var wg sync.WaitGroup
+		for i := range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range 100 {
+					k := fmt.Sprint(i, "-", j)
+					if err := s.Add(ctx, k, e, time.Minute); err != nil {
+						t.Error(err)
+					}
+					_, _ = s.Get(ctx, k)
+					if j%10 == 0 {
+						_ = s.Del(ctx, k)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestDriver_ShardedLRU(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.Levels(entcache.NewShardedLRU(0, 0)))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	ctx := entcache.Cache(context.Background())
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Hits != 1 {
+		t.Errorf("unexpected stats: %v", s)
+	}
+}
+
+// The benchmarks below compare the LRU and ShardedLRU levels under concurrent
+// access. Run them with different -cpu values to observe the lock contention:
+//
+//	go test -run=^$ -bench='LRU' -cpu=1,4,16
+func BenchmarkLRU_Get(b *testing.B) {
+	benchmarkGet(b, entcache.NewLRU(0))
+}
+
+func BenchmarkShardedLRU_Get(b *testing.B) {
+	benchmarkGet(b, entcache.NewShardedLRU(0, 0))
+}
+
+func BenchmarkLRU_Mixed(b *testing.B) {
+	benchmarkMixed(b, entcache.NewLRU(1024))
+}
+
+func BenchmarkShardedLRU_Mixed(b *testing.B) {
+	benchmarkMixed(b, entcache.NewShardedLRU(0, 1024))
+}
+
+const benchKeys = 1024
+
+var benchEntry = &entcache.Entry{
+	Columns: []string{"id", "name"},
+	Values:  [][]driver.Value{{int64(1), "a8m"}, {int64(2), "nati"}},
+}
+
+// benchmarkGet measures concurrent reads of existing entries.
+func benchmarkGet(b *testing.B, c entcache.AddGetDeleter) {
+	ctx := context.Background()
+	for i := range benchKeys {
+		if err := c.Add(ctx, uint64(i), benchEntry, time.Hour); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var seq atomic.Uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := seq.Add(1) * 7919
+		for pb.Next() {
+			if _, err := c.Get(ctx, i%benchKeys); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+// benchmarkMixed measures concurrent access where 1 of every 10
+// operations is a write, and some of the reads are misses.
+func benchmarkMixed(b *testing.B, c entcache.AddGetDeleter) {
+	ctx := context.Background()
+	var seq atomic.Uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := seq.Add(1) * 7919
+		for pb.Next() {
+			k := i % (2 * benchKeys)
+			if i%10 == 0 {
+				if err := c.Add(ctx, k, benchEntry, time.Hour); err != nil {
+					b.Error(err)
+					return
+				}
+			} else {
+				_, _ = c.Get(ctx, k)
+			}
+			i++
+		}
+	})
+}
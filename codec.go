@@ -0,0 +1,332 @@
+package entcache
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec defines the interface for serializing entries that
+// are stored in remote or persistent cache levels.
+//
+// Codecs must preserve the types of the driver values on round-trip:
+// int64, float64, bool, []byte, string, time.Time and nil.
+type Codec interface {
+	// Name returns the name of the codec (e.g. "cbor").
+	Name() string
+	// Marshal returns the encoding of the entry.
+	Marshal(*Entry) ([]byte, error)
+	// Unmarshal decodes the data into the entry.
+	Unmarshal([]byte, *Entry) error
+}
+
+var (
+	// GobCodec encodes entries using encoding/gob. It is the default
+	// codec of the cache levels, and the codec of Entry.MarshalBinary.
+	GobCodec Codec = gobCodec{}
+
+	// CBORCodec encodes entries using CBOR (RFC 8949). Times are encoded as
+	// RFC 3339 strings with nanosecond precision (tag 0).
+	CBORCodec Codec = newCBORCodec()
+
+	// MsgpackCodec encodes entries using MessagePack. Times are encoded
+	// using the timestamp extension type.
+	MsgpackCodec Codec = msgpackCodec{}
+
+	// JSONCodec encodes entries using JSON. Values other than nil, bool and
+	// string are encoded as single-field objects that keep their types:
+	//
+	//	{"i":"1"}                          // int64
+	//	{"f":1.5}                          // float64 ("NaN", "+Inf" and "-Inf" are encoded as strings)
+	//	{"b":"YTht"}                       // []byte (standard base64)
+	//	{"t":"2025-01-02T03:04:05.06Z"}   // time.Time (RFC 3339 with nanoseconds)
+	//
+	// Integers are encoded as strings, as JSON numbers lose precision
+	// beyond 2^53 in most non-Go implementations.
+	JSONCodec Codec = jsonCodec{}
+)
+
+// codecEntry has the same fields as Entry, but does not implement the
+// encoding.BinaryMarshaler interface, which is preferred by some encoders.
+type codecEntry struct {
+	Columns   []string         `cbor:"0,keyasint" msgpack:"c"`
+	Values    [][]driver.Value `cbor:"1,keyasint" msgpack:"v"`
+	StaleAt   time.Time        `cbor:"2,keyasint,omitempty" msgpack:"s,omitempty"`
+	CreatedAt time.Time        `cbor:"3,keyasint,omitempty" msgpack:"t,omitempty"`
+	Delta     time.Duration    `cbor:"4,keyasint,omitempty" msgpack:"d,omitempty"`
+	Tags      []string         `cbor:"5,keyasint,omitempty" msgpack:"g,omitempty"`
+}
+
+func init() {
+	// Register the driver.Value types that are not
+	// encoded by gob as interface values by default.
+	gob.Register(time.Time{})
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(e *Entry) ([]byte, error) {
+	entry := struct {
+		C []string
+		V [][]driver.Value
+		S time.Time
+		T time.Time
+		D time.Duration
+		G []string
+	}{
+		C: e.Columns,
+		V: e.Values,
+		S: e.StaleAt,
+		T: e.CreatedAt,
+		D: e.Delta,
+		G: e.Tags,
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(buf []byte, e *Entry) error {
+	var entry struct {
+		C []string
+		V [][]driver.Value
+		S time.Time
+		T time.Time
+		D time.Duration
+		G []string
+	}
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&entry); err != nil {
+		return err
+	}
+	e.Values = entry.V
+	e.Columns = entry.C
+	e.StaleAt = entry.S
+	e.CreatedAt = entry.T
+	e.Delta = entry.D
+	e.Tags = entry.G
+	return nil
+}
+
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{
+		Time:      cbor.TimeRFC3339Nano,
+		TimeTag:   cbor.EncTagRequired,
+		OmitEmpty: cbor.OmitEmptyGoValue,
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	dec, err := cbor.DecOptions{
+		// Decode all integers into int64, instead of uint64 for positive ones.
+		IntDec: cbor.IntDecConvertSigned,
+		// Decode tagged times into time.Time.
+		TimeTag: cbor.DecTagRequired,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return cborCodec{enc: enc, dec: dec}
+}
+
+func (cborCodec) Name() string { return "cbor" }
+
+func (c cborCodec) Marshal(e *Entry) ([]byte, error) { return c.enc.Marshal((*codecEntry)(e)) }
+
+func (c cborCodec) Unmarshal(buf []byte, e *Entry) error {
+	return c.dec.Unmarshal(buf, (*codecEntry)(e))
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(e *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	// Integers must keep their int64 encoding, as compact
+	// integers are decoded into smaller (or unsigned) types.
+	enc.UseCompactInts(false)
+	enc.UseCompactFloats(false)
+	if err := enc.Encode((*codecEntry)(e)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(buf []byte, e *Entry) error {
+	if err := msgpack.Unmarshal(buf, (*codecEntry)(e)); err != nil {
+		return err
+	}
+	for _, row := range e.Values {
+		for i, v := range row {
+			// Timestamps are decoded in the local time zone.
+			if t, ok := v.(time.Time); ok {
+				row[i] = t.UTC()
+			}
+		}
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(e *Entry) ([]byte, error) {
+	je := jsonEntry{
+		Columns:   e.Columns,
+		Values:    make([][]jsonValue, len(e.Values)),
+		StaleAt:   formatTime(e.StaleAt),
+		CreatedAt: formatTime(e.CreatedAt),
+		Delta:     e.Delta,
+		Tags:      e.Tags,
+	}
+	for i, row := range e.Values {
+		je.Values[i] = make([]jsonValue, len(row))
+		for j, v := range row {
+			je.Values[i][j] = jsonValue{v}
+		}
+	}
+	return json.Marshal(je)
+}
+
+func (jsonCodec) Unmarshal(buf []byte, e *Entry) error {
+	var je jsonEntry
+	if err := json.Unmarshal(buf, &je); err != nil {
+		return err
+	}
+	var err error
+	if e.StaleAt, err = parseTime(je.StaleAt); err != nil {
+		return err
+	}
+	if e.CreatedAt, err = parseTime(je.CreatedAt); err != nil {
+		return err
+	}
+	e.Columns = je.Columns
+	e.Delta = je.Delta
+	e.Tags = je.Tags
+	e.Values = make([][]driver.Value, len(je.Values))
+	for i, row := range je.Values {
+		e.Values[i] = make([]driver.Value, len(row))
+		for j, v := range row {
+			e.Values[i][j] = v.v
+		}
+	}
+	return nil
+}
+
+// formatTime formats the time in RFC 3339, or returns
+// an empty string if the time is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseTime parses the time formatted by formatTime.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// jsonEntry is the JSON representation of an Entry.
+type jsonEntry struct {
+	Columns   []string      `json:"c"`
+	Values    [][]jsonValue `json:"v"`
+	StaleAt   string        `json:"s,omitempty"`
+	CreatedAt string        `json:"t,omitempty"`
+	Delta     time.Duration `json:"d,omitempty"`
+	Tags      []string      `json:"g,omitempty"`
+}
+
+// jsonValue is the JSON representation of a driver value.
+type jsonValue struct {
+	v driver.Value
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (v jsonValue) MarshalJSON() ([]byte, error) {
+	switch x := v.v.(type) {
+	case nil, bool, string:
+		return json.Marshal(x)
+	case int64:
+		return json.Marshal(map[string]string{"i": strconv.FormatInt(x, 10)})
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return json.Marshal(map[string]string{"f": strconv.FormatFloat(x, 'g', -1, 64)})
+		}
+		return json.Marshal(map[string]float64{"f": x})
+	case []byte:
+		return json.Marshal(map[string]string{"b": base64.StdEncoding.EncodeToString(x)})
+	case time.Time:
+		return json.Marshal(map[string]string{"t": x.Format(time.RFC3339Nano)})
+	default:
+		return nil, fmt.Errorf("entcache: unsupported value type for json codec: %T", x)
+	}
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (v *jsonValue) UnmarshalJSON(buf []byte) error {
+	var raw any
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return err
+	}
+	switch x := raw.(type) {
+	case nil, bool, string:
+		v.v = x
+		return nil
+	case map[string]any:
+		if len(x) != 1 {
+			break
+		}
+		for typ, val := range x {
+			return v.decode(typ, val)
+		}
+	}
+	return fmt.Errorf("entcache: unexpected json value: %s", buf)
+}
+
+// decode decodes a typed value.
+func (v *jsonValue) decode(typ string, val any) error {
+	var err error
+	switch s, isString := val.(string); {
+	case typ == "i" && isString:
+		v.v, err = strconv.ParseInt(s, 10, 64)
+	case typ == "f" && isString:
+		v.v, err = strconv.ParseFloat(s, 64)
+	case typ == "f":
+		f, ok := val.(float64)
+		if !ok {
+			return fmt.Errorf("entcache: unexpected json float: %v", val)
+		}
+		v.v = f
+	case typ == "b" && isString:
+		v.v, err = base64.StdEncoding.DecodeString(s)
+	case typ == "t" && isString:
+		v.v, err = time.Parse(time.RFC3339Nano, s)
+	default:
+		return fmt.Errorf("entcache: unexpected json value type: %q", typ)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package entcache_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestCodec(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 60708, time.UTC)
+	e := &entcache.Entry{
+		Columns: []string{"id", "score", "active", "data", "name", "created_at", "deleted_at"},
+		Values: [][]driver.Value{
+			{int64(1), 1.5, true, []byte("a8m"), "a8m", now, nil},
+			{int64(math.MaxInt64), float64(3), false, []byte{}, "", now.Add(time.Hour), nil},
+			{int64(-1), -0.25, false, []byte{0, 0xff}, "ä", time.Unix(0, 0).UTC(), nil},
+		},
+		StaleAt:   now,
+		CreatedAt: now.Add(-time.Minute),
+		Delta:     1500 * time.Microsecond,
+		Tags:      []string{"user:1"},
+	}
+	for _, c := range []entcache.Codec{entcache.GobCodec, entcache.CBORCodec, entcache.MsgpackCodec, entcache.JSONCodec} {
+		t.Run(c.Name(), func(t *testing.T) {
+			buf, err := c.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &entcache.Entry{}
+			if err := c.Unmarshal(buf, got); err != nil {
+				t.Fatal(err)
+			}
+			if !got.StaleAt.Equal(e.StaleAt) || !got.CreatedAt.Equal(e.CreatedAt) || got.Delta != e.Delta {
+				t.Fatalf("unexpected metadata: %v %v %v", got.StaleAt, got.CreatedAt, got.Delta)
+			}
+			if !reflect.DeepEqual(got.Columns, e.Columns) {
+				t.Fatalf("unexpected columns: %v", got.Columns)
+			}
+			if !reflect.DeepEqual(got.Tags, e.Tags) {
+				t.Fatalf("unexpected tags: %v", got.Tags)
+			}
+			if len(got.Values) != len(e.Values) {
+				t.Fatalf("unexpected rows: %v", got.Values)
+			}
+			for i := range e.Values {
+				for j, want := range e.Values[i] {
+					v := got.Values[i][j]
+					if reflect.TypeOf(v) != reflect.TypeOf(want) {
+						t.Fatalf("value (%d, %d): type %T != %T", i, j, v, want)
+					}
+					switch want := want.(type) {
+					case time.Time:
+						if !want.Equal(v.(time.Time)) {
+							t.Fatalf("value (%d, %d): %v != %v", i, j, v, want)
+						}
+					case []byte:
+						// Gob decodes empty slices as nil.
+						if !bytes.Equal(v.([]byte), want) {
+							t.Fatalf("value (%d, %d): %v != %v", i, j, v, want)
+						}
+					default:
+						if !reflect.DeepEqual(v, want) {
+							t.Fatalf("value (%d, %d): %v != %v", i, j, v, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	e := &entcache.Entry{
+		Columns: []string{"id", "name", "score", "data"},
+		Values:  [][]driver.Value{{int64(1), "a8m", math.Inf(1), []byte("a8m")}},
+	}
+	buf, err := entcache.JSONCodec.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(buf); s != `{"c":["id","name","score","data"],"v":[[{"i":"1"},"a8m",{"f":"+Inf"},{"b":"YTht"}]]}` {
+		t.Fatalf("unexpected encoding: %s", s)
+	}
+	got := &entcache.Entry{}
+	if err := entcache.JSONCodec.Unmarshal(buf, got); err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(got.Values[0][2].(float64), 1) {
+		t.Fatalf("unexpected value: %v", got.Values[0][2])
+	}
+	if _, err := entcache.JSONCodec.Marshal(&entcache.Entry{Values: [][]driver.Value{{uint8(1)}}}); err == nil {
+		t.Fatal("expected error for unsupported value type")
+	}
+	for _, s := range []string{`{"v":[[{"x":"1"}]]}`, `{"v":[[{"i":"a"}]]}`, `{"v":[[1]]}`} {
+		if err := entcache.JSONCodec.Unmarshal([]byte(s), &entcache.Entry{}); err == nil {
+			t.Fatalf("expected error for %s", s)
+		}
+	}
+}
+
+func TestMemcache_Codec(t *testing.T) {
+	srv := newFakeMemcached(t)
+	m := entcache.NewMemcache(srv.addr(), entcache.MemcacheCodec(entcache.JSONCodec))
+	defer m.Close()
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"id", "name"}, Values: [][]driver.Value{{int64(1), "a8m"}}}
+	if err := m.Add(ctx, 1, e, 0); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	v := string(srv.items["1"].value)
+	srv.mu.Unlock()
+	if !strings.HasSuffix(v, `{"c":["id","name"],"v":[[{"i":"1"},"a8m"]]}`) {
+		t.Fatalf("unexpected stored value: %s", v)
+	}
+	got, err := m.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Values[0][0] != int64(1) || got.Values[0][1] != "a8m" {
+		t.Fatalf("unexpected entry: %v", got)
+	}
+}
@@ -0,0 +1,143 @@
+package entcache
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression defines the compression algorithm of a compressed codec.
+type Compression byte
+
+// Compression algorithms. The values are used as the header byte of the
+// encoded entries, and are chosen to not collide with the first byte of the
+// encodings of the builtin codecs. Hence, compressed and legacy uncompressed
+// entries can coexist in the same cache.
+const (
+	// CompressionNone marks entries that are stored raw, as they are smaller than the threshold.
+	CompressionNone Compression = 0xDC
+	// CompressionZstd compresses entries using zstd (RFC 8878).
+	CompressionZstd Compression = 0xDD
+	// CompressionS2 compresses entries using S2, an extension of Snappy.
+	// S2 decodes Snappy-compressed data as well.
+	CompressionS2 Compression = 0xDE
+)
+
+// DefaultCompressThreshold is the default size in bytes, below which entries are stored raw.
+const DefaultCompressThreshold = 1 << 10
+
+// String returns the name of the compression algorithm.
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionS2:
+		return "s2"
+	default:
+		return fmt.Sprintf("Compression(%#x)", byte(c))
+	}
+}
+
+// compressCodec is a Codec that compresses the encoding of another codec.
+type compressCodec struct {
+	codec     Codec
+	alg       Compression
+	threshold int
+}
+
+// Compress returns a Codec that compresses the encoding of the given codec using
+// the given algorithm. Encodings that are smaller than the threshold are stored
+// raw, as compressing them does not pay off. If the threshold is zero, the
+// DefaultCompressThreshold is used.
+//
+// Each encoded entry starts with a header byte that identifies its compression.
+// Entries that were compressed with any of the algorithms, and entries that were
+// stored without a header (i.e. by the wrapped codec directly) are all decoded,
+// and therefore, compression can be enabled (or changed) gradually.
+//
+//	entcache.NewRedis(rdb, entcache.RedisCodec(
+//		entcache.Compress(entcache.GobCodec, entcache.CompressionZstd, 0),
+//	))
+func Compress(c Codec, alg Compression, threshold int) Codec {
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	return &compressCodec{codec: c, alg: alg, threshold: threshold}
+}
+
+// Name returns the name of the codec (e.g. "gob+zstd").
+func (c *compressCodec) Name() string {
+	return c.codec.Name() + "+" + c.alg.String()
+}
+
+// Marshal returns the compressed encoding of the entry.
+func (c *compressCodec) Marshal(e *Entry) ([]byte, error) {
+	buf, err := c.codec.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < c.threshold {
+		return append([]byte{byte(CompressionNone)}, buf...), nil
+	}
+	dst := []byte{byte(c.alg)}
+	switch c.alg {
+	case CompressionNone:
+		return append(dst, buf...), nil
+	case CompressionZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(buf, dst), nil
+	case CompressionS2:
+		n := s2.MaxEncodedLen(len(buf))
+		if n < 0 {
+			return nil, fmt.Errorf("entcache: entry is too large for s2: %d bytes", len(buf))
+		}
+		dst = append(dst, make([]byte, n)...)
+		return dst[:1+len(s2.Encode(dst[1:], buf))], nil
+	default:
+		return nil, fmt.Errorf("entcache: unknown compression: %v", c.alg)
+	}
+}
+
+// Unmarshal decompresses the data and decodes it into the entry.
+func (c *compressCodec) Unmarshal(buf []byte, e *Entry) error {
+	if len(buf) == 0 {
+		return c.codec.Unmarshal(buf, e)
+	}
+	var err error
+	switch data := buf[1:]; Compression(buf[0]) {
+	case CompressionNone:
+		buf = data
+	case CompressionZstd:
+		dec, derr := zstdDecoder()
+		if derr != nil {
+			return derr
+		}
+		buf, err = dec.DecodeAll(data, nil)
+	case CompressionS2:
+		buf, err = s2.Decode(nil, data)
+	default:
+		// Entries that were stored without compression header.
+	}
+	if err != nil {
+		return fmt.Errorf("entcache: decompress entry: %w", err)
+	}
+	return c.codec.Unmarshal(buf, e)
+}
+
+var (
+	// zstd encoders and decoders are safe for concurrent use with
+	// EncodeAll and DecodeAll, and are shared by all codecs.
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+)
@@ -0,0 +1,77 @@
+package entcache_test
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestCompress(t *testing.T) {
+	small := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	large := &entcache.Entry{Columns: []string{"name"}}
+	for range 100 {
+		large.Values = append(large.Values, []driver.Value{strings.Repeat("a8m", 10)})
+	}
+	legacy, err := entcache.GobCodec.Marshal(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []entcache.Compression{entcache.CompressionZstd, entcache.CompressionS2} {
+		t.Run(alg.String(), func(t *testing.T) {
+			c := entcache.Compress(entcache.GobCodec, alg, 0)
+			if n := c.Name(); n != "gob+"+alg.String() {
+				t.Fatalf("unexpected name: %q", n)
+			}
+			buf, err := c.Marshal(small)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf[0] != byte(entcache.CompressionNone) {
+				t.Fatalf("expected small entry to be stored raw, got header: %#x", buf[0])
+			}
+			expectDecode(t, c, buf, small)
+
+			buf, err = c.Marshal(large)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf[0] != byte(alg) {
+				t.Fatalf("unexpected header: %#x", buf[0])
+			}
+			if len(buf) >= len(legacy) {
+				t.Fatalf("expected entry to be compressed: %d >= %d", len(buf), len(legacy))
+			}
+			expectDecode(t, c, buf, large)
+
+			// Legacy entries, and entries of other algorithms.
+			expectDecode(t, c, legacy, large)
+			other := entcache.CompressionS2
+			if alg == other {
+				other = entcache.CompressionZstd
+			}
+			buf, err = entcache.Compress(entcache.GobCodec, other, 0).Marshal(large)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectDecode(t, c, buf, large)
+
+			buf[len(buf)/2] ^= 0xff
+			if err := c.Unmarshal(buf[:len(buf)-1], &entcache.Entry{}); err == nil {
+				t.Fatal("expected error for corrupted entry")
+			}
+		})
+	}
+}
+
+func expectDecode(t *testing.T, c entcache.Codec, buf []byte, want *entcache.Entry) {
+	t.Helper()
+	got := &entcache.Entry{}
+	if err := c.Unmarshal(buf, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Values) != len(want.Values) || got.Values[0][0] != want.Values[0][0] {
+		t.Fatalf("unexpected entry: %v", got)
+	}
+}
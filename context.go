@@ -32,11 +32,17 @@ type ctxOptions struct {
 	cache     bool          // i.e. cache entry.
 	evict     bool          // i.e. cache and invalidate entry.
 	cacheOnly bool          // i.e. skip database execution, cache-only operation.
+	refresh   bool          // i.e. skip cache read, and overwrite entry.
 	key       Key           // entry key.
 	ttl       time.Duration // entry duration.
+	tags      []string      // entry tags.
+	lease     string        // entry lease token.
 }
 
-var ctxOptionsKey ctxOptions
+// optionsKey is the context key of the ctxOptions.
+type optionsKey struct{}
+
+var ctxOptionsKey optionsKey
 
 // QueryOption configures cache behavior for a query.
 type QueryOption func(*ctxOptions)
@@ -71,6 +77,18 @@ func Evict() QueryOption {
 	}
 }
 
+// Refresh configures the driver to skip reading the entry from the cache, execute
+// the query, and overwrite the cached entry with its result. Unlike Evict, the
+// previous entry is served to other queries until it is overwritten. It has no
+// effect when combined with CacheOnly().
+//
+//	users, err := client.User.Query().All(entcache.Cache(ctx, Refresh()))
+func Refresh() QueryOption {
+	return func(o *ctxOptions) {
+		o.refresh = true
+	}
+}
+
 // WithKey sets a custom cache key instead of generating one from the query.
 // Note that this option should not be used if the ent.Client query involves
 // more than 1 SQL query (e.g., eager loading).
@@ -91,6 +109,18 @@ func WithTTL(ttl time.Duration) QueryOption {
 	}
 }
 
+// WithTags associates the cache entry with the given tags. All entries
+// that share a tag can be invalidated at once using Driver.InvalidateTags.
+// Tags require a cache level that implements the Tagger interface, such as
+// LRU and Redis.
+//
+//	users, err := client.User.Query().All(entcache.Cache(ctx, WithTags("tenant:acme")))
+func WithTags(tags ...string) QueryOption {
+	return func(o *ctxOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
 // Cache returns a context that enables caching for the query.
 // Accepts optional configuration via functional options.
 //
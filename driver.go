@@ -6,7 +6,10 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	_ "unsafe" // to link convertAssign
@@ -43,6 +46,61 @@ type (
 		// with only one query executed and the result shared among all callers.
 		// Default is false.
 		Singleflight bool
+
+		// TableInvalidation enables automatic invalidation of cache entries
+		// when the tables they were read from are modified by statements
+		// executed through the driver. Default is false.
+		TableInvalidation bool
+
+		// TableIndexSize limits the number of keys that are indexed for
+		// table invalidation. The default is DefaultTableIndexSize, and
+		// a negative value means no limit.
+		TableIndexSize int
+
+		// TxCache enables serving queries that are executed inside
+		// a transaction from the cache. Default is false.
+		TxCache bool
+
+		// XFetchBeta enables probabilistic early recomputation of entries
+		// before their TTL expires, and scales its eagerness. Zero (the
+		// default) disables this mode.
+		XFetchBeta float64
+
+		// StaleWhileRevalidate defines the grace period after the TTL of an
+		// Entry expires, in which it is served stale while it is refreshed
+		// in the background. Zero (the default) disables this mode.
+		StaleWhileRevalidate time.Duration
+
+		// DistributedLock defines the expiry of the locks that are acquired
+		// in the cache on misses, for coalescing identical queries across
+		// processes. Zero (the default) disables distributed locking.
+		DistributedLock time.Duration
+
+		// Lease defines the expiry of the leases that are obtained in the
+		// cache on misses, for rejecting entries that were invalidated while
+		// their query was executed. Zero (the default) disables leases.
+		Lease time.Duration
+
+		// WarmupConcurrency defines the maximum number of queries that are
+		// executed concurrently by Driver.Warmup. The default is 8.
+		WarmupConcurrency int
+
+		// HotQueries defines the number of the most frequently executed
+		// queries that are recorded for Driver.HotQueries. Zero (the default)
+		// disables recording.
+		HotQueries int
+
+		// FaultTolerant enables partial failure semantics for multi-level
+		// caches, and FaultBackoff defines the period in which a failing
+		// level is skipped. Default is false.
+		FaultTolerant bool
+		FaultBackoff  time.Duration
+
+		// AsyncQueueSize enables storing entries in the background, and
+		// defines the size of the write queue, and AsyncWorkers defines
+		// the number of workers. Zero (the default) disables async writes.
+		AsyncQueueSize int
+		AsyncWorkers   int
 	}
 
 	// Option allows configuring the cache
@@ -54,8 +112,17 @@ type (
 	Driver struct {
 		dialect.Driver
 		*Options
-		stats Stats
-		group singleflight.Group
+		stats  Stats
+		group  singleflight.Group
+		tables tableIndex
+		// keys of the entries that are being revalidated.
+		revalidating sync.Map
+		// refresh-ahead jobs.
+		refresher refresher
+		// frequently executed queries.
+		hot hotQueries
+		// async cache writes.
+		writer *writer
 	}
 )
 
@@ -82,14 +149,23 @@ func CacheOnlySentinelColumns() []string {
 //		)
 //	)
 func NewDriver(drv dialect.Driver, opts ...Option) *Driver {
-	options := &Options{Hash: DefaultHash, Cache: NewLRU(0)}
+	options := &Options{Hash: DefaultHash, Cache: NewLRU(0), WarmupConcurrency: DefaultWarmupConcurrency, TableIndexSize: DefaultTableIndexSize}
 	for _, opt := range opts {
 		opt(options)
 	}
-	return &Driver{
+	if m, ok := options.Cache.(*multiLevel); ok && options.FaultTolerant {
+		m.tolerant(options.FaultBackoff)
+	}
+	d := &Driver{
 		Driver:  drv,
 		Options: options,
+		hot:     hotQueries{n: options.HotQueries},
+		tables:  tableIndex{max: max(options.TableIndexSize, 0)},
 	}
+	if options.AsyncQueueSize > 0 {
+		d.writer = newWriter(options.AsyncQueueSize, options.AsyncWorkers)
+	}
+	return d
 }
 
 // TTL configures the period of time that an Entry
@@ -120,6 +196,28 @@ func Levels(levels ...AddGetDeleter) Option {
 	}
 }
 
+// FaultTolerant configures the multi-level cache (see Levels) to tolerate failures
+// of some of its levels. By default, Add and Del stop at the first failing level,
+// and Get fails if a level fails. With fault tolerance, operations continue to the
+// other levels, and their errors are joined. Hence, an outage of a remote level
+// (e.g., Redis) does not affect the in-process levels. Failed gets are treated as
+// misses, and levels that fail are marked as unhealthy and skipped for the given
+// backoff window (zero disables skipping), except for deletions that are applied
+// to all levels. Errors that levels report as misses (e.g., network errors of Redis)
+// count as failures. The error counts of the levels are reported by Driver.LevelStats.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.Levels(entcache.NewLRU(256), entcache.NewRedis(rdb)),
+//		entcache.FaultTolerant(10*time.Second),
+//	)
+func FaultTolerant(backoff time.Duration) Option {
+	return func(o *Options) {
+		o.FaultTolerant = true
+		o.FaultBackoff = backoff
+	}
+}
+
 // ContextLevel configures the driver to work with context/request level cache.
 // Users that use this option should wrap the *http.Request context with the
 // cache value as follows:
@@ -143,6 +241,138 @@ func WithSingleflight(enabled bool) Option {
 	}
 }
 
+// WithTableInvalidation enables or disables automatic table-based invalidation.
+// When enabled, the driver records the tables that are read by each cached query
+// (including JOINs and subqueries), and evicts every dependent entry when a statement
+// that modifies one of these tables (e.g., INSERT, UPDATE, DELETE) is executed.
+//
+// Note that the table index is kept in process memory. Entries that were stored in
+// a shared cache level (e.g., Redis) by other processes are evicted only by their TTL.
+// The size of the index is limited by TableIndexSize.
+func WithTableInvalidation(enabled bool) Option {
+	return func(o *Options) {
+		o.TableInvalidation = enabled
+	}
+}
+
+// DefaultTableIndexSize is the default value of Options.TableIndexSize.
+const DefaultTableIndexSize = 1 << 16
+
+// TableIndexSize limits the number of keys that are indexed for table invalidation
+// (see WithTableInvalidation). When the index is full, the oldest keys are dropped
+// from it, and their entries are evicted from the cache, as they would not be
+// invalidated anymore when their tables are modified. A negative value means
+// no limit.
+func TableIndexSize(n int) Option {
+	return func(o *Options) {
+		o.TableIndexSize = n
+	}
+}
+
+// WithTxCache enables or disables serving queries inside transactions from the cache.
+// When enabled, queries that are executed inside a transaction started by Driver.Tx
+// or Driver.BeginTx are served from the cache, unless they read from a table that was
+// modified by the transaction. Query results inside transactions are never cached.
+func WithTxCache(enabled bool) Option {
+	return func(o *Options) {
+		o.TxCache = enabled
+	}
+}
+
+// StaleWhileRevalidate configures the driver to serve entries whose TTL expired
+// within the given grace period immediately, while a single background query per
+// entry refreshes them in the cache. Entries are stored in the cache levels with
+// a TTL that includes the grace period (i.e. their hard expiry), and hold the
+// time they become stale (i.e. their soft expiry). Entries without a TTL never
+// become stale.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.TTL(time.Minute),
+//		entcache.StaleWhileRevalidate(10*time.Minute),
+//	)
+func StaleWhileRevalidate(grace time.Duration) Option {
+	return func(o *Options) {
+		o.StaleWhileRevalidate = grace
+	}
+}
+
+// XFetch configures the driver to recompute entries before their TTL expires,
+// with a probability that grows as the expiry approaches, and with the time it
+// took to compute the entry (i.e. the XFetch algorithm). Since the decision is
+// made independently by each process and query, a hot entry is refreshed early
+// by a few callers instead of expiring everywhere at once. Entries that were
+// recomputed early are refreshed in the background, and the current entry is
+// served in the meantime.
+//
+// Beta scales the eagerness: 1 is a good default, larger values recompute
+// earlier, and smaller values recompute closer to the expiry. Entries record
+// their creation time and compute duration when this mode is enabled.
+func XFetch(beta float64) Option {
+	return func(o *Options) {
+		o.XFetchBeta = beta
+	}
+}
+
+// WithDistributedLock enables request coalescing for identical queries across
+// processes, using a lock that is acquired in the cache (e.g., SET NX in Redis).
+// On a cache miss, only the process that acquired the lock executes the query
+// and stores its result, while the others poll the cache until the entry is
+// stored. If the lock expires before that (e.g., its holder died), the waiting
+// processes execute the query themselves. Hence, the expiry should be longer
+// than the typical query duration.
+//
+// The lock is acquired in the first level that implements the Locker interface,
+// and misses are handled as usual if there is no such level. It can be combined
+// with WithSingleflight to coalesce identical queries within each process too.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.TTL(time.Minute),
+//		entcache.Levels(entcache.NewLRU(256), entcache.NewRedis(rdb)),
+//		entcache.WithDistributedLock(5*time.Second),
+//	)
+func WithDistributedLock(expiry time.Duration) Option {
+	return func(o *Options) {
+		o.DistributedLock = expiry
+	}
+}
+
+// WithLease enables leases, which prevent storing stale entries in the cache.
+// Without leases, a query that misses the cache and is executed concurrently
+// with a statement that modifies its rows may store its (now stale) result after
+// the entry was invalidated, and it is served until its TTL expires. With leases,
+// the driver obtains a lease token from the cache before executing the query,
+// invalidations (i.e. Del and InvalidateTags) revoke all outstanding leases of
+// their keys, and results with revoked leases are not stored.
+//
+// Leases are supported by levels that implement the Leaser interface, such as
+// LRU and Redis. Results of queries that take longer than the lease expiry are
+// not stored.
+//
+//	entcache.NewDriver(
+//		drv,
+//		entcache.TTL(time.Minute),
+//		entcache.WithTableInvalidation(true),
+//		entcache.WithLease(10*time.Second),
+//	)
+func WithLease(expiry time.Duration) Option {
+	return func(o *Options) {
+		o.Lease = expiry
+	}
+}
+
+// Exec implements the Execer interface for the driver. If table invalidation is
+// enabled, cache entries that depend on the tables modified by the statement are
+// evicted after it was executed successfully.
+func (d *Driver) Exec(ctx context.Context, query string, args, v any) error {
+	if err := d.Driver.Exec(ctx, query, args, v); err != nil {
+		return err
+	}
+	d.invalidate(ctx, query)
+	return nil
+}
+
 // Query implements the Querier interface for the driver. It falls back to the
 // underlying wrapped driver in case of caching error.
 //
@@ -155,8 +385,12 @@ func (d *Driver) Query(ctx context.Context, query string, args, v any) error {
 	// Custom queries (e.g., CTE) or statements that are prefixed with comments are not supported.
 	// This check is mainly necessary because PostgreSQL and SQLite
 	// may execute an insert statement like "INSERT ... RETURNING" using Driver.Query.
-	if !strings.HasPrefix(query, "SELECT") && !strings.HasPrefix(query, "select") {
-		return d.Driver.Query(ctx, query, args, v)
+	if !cacheable(query) {
+		if err := d.Driver.Query(ctx, query, args, v); err != nil {
+			return err
+		}
+		d.invalidate(ctx, query)
+		return nil
 	}
 	vr, ok := v.(*sql.Rows)
 	if !ok {
@@ -170,7 +404,16 @@ func (d *Driver) Query(ctx context.Context, query string, args, v any) error {
 	if err != nil {
 		return d.Driver.Query(ctx, query, args, v)
 	}
+	if opts.refresh && !opts.cacheOnly {
+		e, err := d.load(ctx, query, argv, opts)
+		if err != nil {
+			return err
+		}
+		vr.ColumnScanner = &repeater{columns: e.Columns, values: e.Values}
+		return nil
+	}
 	atomic.AddUint64(&d.stats.Gets, 1)
+	d.hot.record(query, argv, opts)
 
 	// Handle cache-only mode - skip database execution
 	if opts.cacheOnly {
@@ -199,22 +442,54 @@ func (d *Driver) Query(ctx context.Context, query string, args, v any) error {
 	case err == nil:
 		atomic.AddUint64(&d.stats.Hits, 1)
 		vr.ColumnScanner = &repeater{columns: e.Columns, values: e.Values}
+		switch {
+		case stale(e):
+			atomic.AddUint64(&d.stats.Stale, 1)
+			d.revalidate(ctx, query, argv, opts)
+		case d.recomputeEarly(e, opts.ttl):
+			atomic.AddUint64(&d.stats.Early, 1)
+			d.revalidate(ctx, query, argv, opts)
+		}
 	case errors.Is(err, ErrNotFound):
 		// Use singleflight if enabled to deduplicate concurrent identical queries
 		if d.Singleflight {
 			return d.queryWithSingleflight(ctx, query, argv, vr, opts)
 		}
+		if d.DistributedLock > 0 {
+			e, err := d.queryWithLock(ctx, query, argv, opts)
+			if err != nil {
+				return err
+			}
+			vr.ColumnScanner = &repeater{columns: e.Columns, values: e.Values}
+			return nil
+		}
+		opts = d.lease(ctx, query, opts)
+		start := time.Now()
 		if err := d.Driver.Query(ctx, query, args, vr); err != nil {
 			return err
 		}
 		vr.ColumnScanner = &recorder{
 			ColumnScanner: vr.ColumnScanner,
-			onClose: func(columns []string, values [][]driver.Value) {
-				err := d.Cache.Add(ctx, opts.key, &Entry{Columns: columns, Values: values}, opts.ttl)
-				if err != nil && d.Log != nil {
-					atomic.AddUint64(&d.stats.Errors, 1)
-					d.Log(fmt.Sprintf("entcache: failed storing entry %v in cache: %v", opts.key, err))
+			end:           time.Now(),
+			onClose: func(columns []string, values [][]driver.Value, end time.Time) {
+				e, delta := &Entry{Columns: columns, Values: values}, end.Sub(start)
+				// The write outlives the query that triggered it.
+				if d.writer != nil {
+					var (
+						index   func()
+						dropped []Key
+					)
+					// The key is indexed when the write is queued, so it is
+					// canceled by invalidations that happen before it runs.
+					if d.TableInvalidation {
+						index = func() { dropped = d.tables.add(opts.key, readTables(query), d.entryTTL(opts.ttl)) }
+					}
+					if d.writer.enqueue(opts.key, opts.tags, func() { d.add(context.WithoutCancel(ctx), query, opts, e, delta) }, index) {
+						d.evictKeys(ctx, dropped)
+						return
+					}
 				}
+				d.add(ctx, query, opts, e, delta)
 			},
 		}
 	default:
@@ -223,14 +498,299 @@ func (d *Driver) Query(ctx context.Context, query string, args, v any) error {
 	return nil
 }
 
+// cacheable reports if the given statement looks like a standard Ent query (e.g., SELECT).
+func cacheable(query string) bool {
+	return strings.HasPrefix(query, "SELECT") || strings.HasPrefix(query, "select")
+}
+
+// add stores the entry in the cache, and indexes its key by the tables it
+// was read from if table invalidation is enabled. The delta is the duration
+// of the query execution (until its rows were read), and is recorded as the
+// compute duration of the entry.
+func (d *Driver) add(ctx context.Context, query string, opts ctxOptions, e *Entry, delta time.Duration) {
+	if d.XFetchBeta > 0 {
+		e.CreatedAt = time.Now()
+		e.Delta = delta
+	}
+	ttl := d.entryTTL(opts.ttl)
+	if ttl != opts.ttl {
+		e.StaleAt = time.Now().Add(opts.ttl)
+	}
+	if d.TableInvalidation {
+		// The key is indexed before the entry is stored, to ensure
+		// it is not missed by concurrent invalidations.
+		d.evictKeys(ctx, d.tables.add(opts.key, readTables(query), ttl))
+	}
+	if len(opts.tags) > 0 {
+		e.Tags = opts.tags
+		// Leased keys were tagged before their query was executed.
+		if opts.lease == "" {
+			d.tagKey(ctx, opts)
+		}
+	}
+	var err error
+	if opts.lease != "" {
+		err = d.Cache.(Leaser).AddLease(ctx, opts.key, opts.lease, e, ttl)
+	} else {
+		err = d.Cache.Add(ctx, opts.key, e, ttl)
+	}
+	// Entries whose lease was revoked are stale, and skipped.
+	if err != nil && !errors.Is(err, ErrLeaseRevoked) && d.Log != nil {
+		atomic.AddUint64(&d.stats.Errors, 1)
+		d.Log(fmt.Sprintf("entcache: failed storing entry %v in cache: %v", opts.key, err))
+	}
+}
+
+// lease obtains a lease for the entry before its query is executed,
+// if leases are enabled and supported by the cache.
+func (d *Driver) lease(ctx context.Context, query string, opts ctxOptions) ctxOptions {
+	l, ok := d.Cache.(Leaser)
+	if !ok || d.Lease <= 0 {
+		return opts
+	}
+	if d.TableInvalidation {
+		// The key is indexed before the query is executed, so statements
+		// that modify its tables meanwhile delete it and revoke its lease.
+		d.evictKeys(ctx, d.tables.add(opts.key, readTables(query), d.entryTTL(opts.ttl)))
+	}
+	if len(opts.tags) > 0 {
+		// Similarly, the key is tagged before the query is executed,
+		// so invalidations of its tags meanwhile revoke its lease.
+		d.tagKey(ctx, opts)
+	}
+	token, err := l.Lease(ctx, opts.key, d.Lease)
+	if err != nil && d.Log != nil {
+		atomic.AddUint64(&d.stats.Errors, 1)
+		d.Log(fmt.Sprintf("entcache: failed leasing entry %v in cache: %v", opts.key, err))
+	}
+	opts.lease = token
+	return opts
+}
+
+// entryTTL returns the TTL of the entry in the cache levels. If stale-while-
+// revalidate is enabled, levels store the entry until its hard expiry.
+func (d *Driver) entryTTL(ttl time.Duration) time.Duration {
+	if d.StaleWhileRevalidate > 0 && ttl > 0 {
+		return ttl + d.StaleWhileRevalidate
+	}
+	return ttl
+}
+
+// stale reports if the entry is past its soft expiry.
+func stale(e *Entry) bool {
+	return !e.StaleAt.IsZero() && !time.Now().Before(e.StaleAt)
+}
+
+// recomputeEarly reports if the entry should be recomputed before it expires,
+// using the XFetch algorithm: the probability grows as the entry approaches its
+// expiry, and with the time it took to compute it.
+func (d *Driver) recomputeEarly(e *Entry, ttl time.Duration) bool {
+	if d.XFetchBeta <= 0 || ttl <= 0 || e.CreatedAt.IsZero() || e.Delta <= 0 {
+		return false
+	}
+	// -ln(u) for u in (0, 1] is exponentially distributed. The gap is
+	// compared as a float, as it may overflow time.Duration.
+	gap := float64(e.Delta) * d.XFetchBeta * -math.Log(1-rand.Float64())
+	return gap >= float64(time.Until(e.CreatedAt.Add(ttl)))
+}
+
+// revalidate refreshes the entry in the background, unless
+// it is already being refreshed by another call.
+func (d *Driver) revalidate(ctx context.Context, query string, args []any, opts ctxOptions) {
+	key := fmt.Sprint(opts.key)
+	if _, loaded := d.revalidating.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	// The refresh outlives the query that triggered it.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer d.revalidating.Delete(key)
+		if _, err := d.load(ctx, query, args, opts); err != nil && d.Log != nil {
+			atomic.AddUint64(&d.stats.Errors, 1)
+			d.Log(fmt.Sprintf("entcache: failed revalidating entry %v: %v", opts.key, err))
+		}
+	}()
+}
+
+// load executes the query, and stores its result in the cache.
+func (d *Driver) load(ctx context.Context, query string, args []any, opts ctxOptions) (*Entry, error) {
+	opts = d.lease(ctx, query, opts)
+	start := time.Now()
+	var rows sql.Rows
+	if err := d.Driver.Query(ctx, query, args, &rows); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	e, err := materializeRows(rows.ColumnScanner)
+	if err != nil {
+		return nil, err
+	}
+	d.add(ctx, query, opts, e, time.Since(start))
+	return e, nil
+}
+
+// queryWithLock executes the query while holding the distributed lock of its
+// key. If the lock is held by another process, it waits for the entry to be
+// stored by the holder, and executes the query itself if the lock expires.
+func (d *Driver) queryWithLock(ctx context.Context, query string, args []any, opts ctxOptions) (*Entry, error) {
+	switch token, ok, err := d.lock(ctx, opts.key); {
+	case errors.Is(err, errNoLocker):
+	case err != nil:
+		if d.Log != nil {
+			atomic.AddUint64(&d.stats.Errors, 1)
+			d.Log(fmt.Sprintf("entcache: failed locking entry %v in cache: %v", opts.key, err))
+		}
+	case ok:
+		defer d.unlock(context.WithoutCancel(ctx), opts.key, token)
+	default:
+		if e, ok := d.wait(ctx, opts.key); ok {
+			atomic.AddUint64(&d.stats.Coalesced, 1)
+			return e, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return d.load(ctx, query, args, opts)
+}
+
+// wait polls the cache until the entry of the given key is stored, or
+// the distributed lock expires. It reports if the entry was found.
+func (d *Driver) wait(ctx context.Context, k Key) (*Entry, bool) {
+	ticker := time.NewTicker(min(max(d.DistributedLock/20, time.Millisecond), 50*time.Millisecond))
+	defer ticker.Stop()
+	for deadline := time.Now().Add(d.DistributedLock); time.Now().Before(deadline); {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+		}
+		switch e, err := d.Cache.Get(ctx, k); {
+		case err == nil:
+			return e, true
+		case !errors.Is(err, ErrNotFound):
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// lock acquires the distributed lock of the entry, if the cache supports it.
+func (d *Driver) lock(ctx context.Context, k Key) (string, bool, error) {
+	l, ok := d.Cache.(Locker)
+	if !ok {
+		return "", false, errNoLocker
+	}
+	return l.Lock(ctx, k, d.DistributedLock)
+}
+
+// unlock releases the distributed lock of the entry.
+func (d *Driver) unlock(ctx context.Context, k Key, token string) {
+	if err := d.Cache.(Locker).Unlock(ctx, k, token); err != nil && d.Log != nil {
+		atomic.AddUint64(&d.stats.Errors, 1)
+		d.Log(fmt.Sprintf("entcache: failed unlocking entry %v in cache: %v", k, err))
+	}
+}
+
+// tagKey associates the key of the entry with its tags, and logs failures.
+func (d *Driver) tagKey(ctx context.Context, opts ctxOptions) {
+	if err := d.tag(ctx, opts.key, opts.tags, d.entryTTL(opts.ttl)); err != nil && d.Log != nil {
+		atomic.AddUint64(&d.stats.Errors, 1)
+		d.Log(fmt.Sprintf("entcache: failed tagging entry %v in cache: %v", opts.key, err))
+	}
+}
+
+// tag associates the entry with the given tags, if the cache supports it.
+func (d *Driver) tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	t, ok := d.Cache.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.Tag(ctx, k, tags, ttl)
+}
+
+// InvalidateTags evicts all cache entries that were stored with at least one of
+// the given tags (see WithTags). It fails if the configured cache does not support
+// tags, or if some of its levels do not support them (e.g., Disk), as they may keep
+// serving the invalidated entries.
+func (d *Driver) InvalidateTags(ctx context.Context, tags ...string) error {
+	t, ok := d.Cache.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	if d.writer != nil {
+		d.writer.cancelTags(tags...)
+	}
+	return t.InvalidateTags(ctx, tags...)
+}
+
+// invalidate evicts the cache entries that depend on the tables
+// modified by the given statement, if table invalidation is enabled.
+func (d *Driver) invalidate(ctx context.Context, query string) {
+	if d.TableInvalidation {
+		d.invalidateTables(ctx, writeTables(query))
+	}
+}
+
+// invalidateTables evicts the cache entries that depend on the given tables.
+func (d *Driver) invalidateTables(ctx context.Context, tables []string) {
+	if len(tables) == 0 {
+		return
+	}
+	d.evictKeys(ctx, d.tables.take(tables))
+}
+
+// evictKeys evicts the entries of the given keys from the
+// cache, and cancels their queued writes (see AsyncWrites).
+func (d *Driver) evictKeys(ctx context.Context, keys []Key) {
+	if len(keys) == 0 {
+		return
+	}
+	if d.writer != nil {
+		d.writer.cancel(keys...)
+	}
+	for _, k := range keys {
+		if err := d.Cache.Del(ctx, k); err != nil && d.Log != nil {
+			atomic.AddUint64(&d.stats.Errors, 1)
+			d.Log(fmt.Sprintf("entcache: failed evicting entry %v from cache: %v", k, err))
+		}
+	}
+}
+
 // Stats return a copy of the cache statistics.
 func (d *Driver) Stats() Stats {
-	return Stats{
+	s := Stats{
 		Gets:      atomic.LoadUint64(&d.stats.Gets),
 		Hits:      atomic.LoadUint64(&d.stats.Hits),
 		Errors:    atomic.LoadUint64(&d.stats.Errors),
 		Coalesced: atomic.LoadUint64(&d.stats.Coalesced),
+		Stale:     atomic.LoadUint64(&d.stats.Stale),
+		Early:     atomic.LoadUint64(&d.stats.Early),
+		Bytes:     d.size(),
 	}
+	if d.writer != nil {
+		s.Queued = d.writer.queued.Load()
+		s.Dropped = d.writer.dropped.Load()
+		s.Pending = d.writer.size()
+	}
+	return s
+}
+
+// LevelStats returns the statistics of the cache levels, if the
+// driver is configured with multiple levels (see Levels).
+func (d *Driver) LevelStats() []LevelStats {
+	if m, ok := d.Cache.(*multiLevel); ok {
+		return m.stats()
+	}
+	return nil
+}
+
+// size returns the size of the cache, if it supports it.
+func (d *Driver) size() int64 {
+	if s, ok := d.Cache.(Sizer); ok {
+		return s.Size()
+	}
+	return 0
 }
 
 // materializeRows fully consumes a ColumnScanner and returns an Entry.
@@ -274,28 +834,12 @@ func (d *Driver) queryWithSingleflight(ctx context.Context, query string, args [
 	queryCtx := context.WithoutCancel(ctx)
 
 	v, err, shared := d.group.Do(sfKey, func() (any, error) {
-		// Execute the query
-		var rows sql.Rows
-		if err := d.Driver.Query(queryCtx, query, args, &rows); err != nil {
-			return nil, err
-		}
-		defer rows.Close()
-
-		// Materialize all rows
-		entry, err := materializeRows(rows.ColumnScanner)
-		if err != nil {
-			return nil, err
-		}
-
-		// Cache the result
-		if err := d.Cache.Add(queryCtx, opts.key, entry, opts.ttl); err != nil {
-			if d.Log != nil {
-				atomic.AddUint64(&d.stats.Errors, 1)
-				d.Log(fmt.Sprintf("entcache: failed storing entry %v in cache: %v", opts.key, err))
-			}
+		// Coalesce the query across processes too, if enabled.
+		if d.DistributedLock > 0 {
+			return d.queryWithLock(queryCtx, query, args, opts)
 		}
-
-		return entry, nil
+		// Execute the query, and cache its materialized result.
+		return d.load(queryCtx, query, args, opts)
 	})
 
 	if err != nil {
@@ -327,6 +871,8 @@ func (d *Driver) QueryContext(ctx context.Context, query string, args ...any) (*
 }
 
 // ExecContext calls ExecContext of the underlying driver, or fails if it is not supported.
+// Like Exec, it evicts the cache entries that depend on the modified tables, if table
+// invalidation is enabled.
 func (d *Driver) ExecContext(ctx context.Context, query string, args ...any) (stdsql.Result, error) {
 	drv, ok := d.Driver.(interface {
 		ExecContext(context.Context, string, ...any) (stdsql.Result, error)
@@ -334,7 +880,12 @@ func (d *Driver) ExecContext(ctx context.Context, query string, args ...any) (st
 	if !ok {
 		return nil, errors.New("Driver.ExecContext is not supported")
 	}
-	return drv.ExecContext(ctx, query, args...)
+	res, err := drv.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	d.invalidate(ctx, query)
+	return res, nil
 }
 
 // errSkip tells the driver to skip cache layer.
@@ -360,6 +911,10 @@ func (d *Driver) optionsFromContext(ctx context.Context, query string, args []an
 	}
 
 	if opts.evict {
+		d.tables.del(opts.key)
+		if d.writer != nil {
+			d.writer.cancel(opts.key)
+		}
 		if err := d.Cache.Del(ctx, opts.key); err != nil {
 			return opts, err
 		}
@@ -394,7 +949,13 @@ type Stats struct {
 	Gets      uint64
 	Hits      uint64
 	Errors    uint64
-	Coalesced uint64 // Number of queries that were coalesced via singleflight
+	Coalesced uint64 // Number of queries that were coalesced via singleflight or a distributed lock
+	Stale     uint64 // Number of stale entries that were served while being revalidated
+	Early     uint64 // Number of entries that were recomputed early by XFetch
+	Bytes     int64  // Approximate size of the cached entries in the (first) level, if it implements Sizer. See LevelStats
+	Queued    uint64 // Number of entries that were queued for async writes
+	Dropped   uint64 // Number of async writes that were dropped as the queue was full
+	Pending   int64  // Number of async writes that are queued or running
 }
 
 // rawCopy copies the driver values by implementing
@@ -421,12 +982,18 @@ type recorder struct {
 	values  [][]driver.Value
 	columns []string
 	done    bool
-	onClose func([]string, [][]driver.Value)
+	// end is the time the rows were read, or the query
+	// returned if they were not read to their end.
+	end     time.Time
+	onClose func([]string, [][]driver.Value, time.Time)
 }
 
 // Next wraps the underlying Next method
 func (r *recorder) Next() bool {
 	hasNext := r.ColumnScanner.Next()
+	if !hasNext && !r.done {
+		r.end = time.Now()
+	}
 	r.done = !hasNext
 	return hasNext
 }
@@ -473,7 +1040,7 @@ func (r *recorder) Close() error {
 	// and we scanned all rows, we store it on cache.
 	if err := r.Err(); err == nil || r.done {
 		r.ensureColumnsCaptured()
-		r.onClose(r.columns, r.values)
+		r.onClose(r.columns, r.values, r.end)
 	}
 	return nil
 }
@@ -144,7 +144,10 @@ func TestDriver_Levels(t *testing.T) {
 		// Enable caching explicitly
 		ctx := entcache.Cache(context.Background())
 
-		rdb.EXPECT().Do(ctx, ruemock.Match("GET", "1")).Return(ruemock.Result(ruemock.RedisNil()))
+		rdb.EXPECT().DoMulti(ctx, ruemock.Match("GET", "1"), ruemock.Match("PTTL", "1")).Return([]rueidis.RedisResult{
+			ruemock.Result(ruemock.RedisNil()),
+			ruemock.Result(ruemock.RedisInt64(-2)),
+		})
 		mock.ExpectQuery("SELECT active FROM users").
 			WillReturnRows(sqlmock.NewRows([]string{"active"}).AddRow(true).AddRow(false))
 
@@ -154,7 +157,10 @@ func TestDriver_Levels(t *testing.T) {
 		rdb.EXPECT().Do(ctx, ruemock.Match("SET", "1", rueidis.BinaryString(buf), "EX", "0")).Return(ruemock.Result(ruemock.RedisNil()))
 		expectQuery(ctx, t, drv, "SELECT active FROM users", []any{true, false})
 
-		rdb.EXPECT().Do(ctx, ruemock.Match("GET", "1")).Return(ruemock.Result(ruemock.RedisString(rueidis.BinaryString(buf))))
+		rdb.EXPECT().DoMulti(ctx, ruemock.Match("GET", "1"), ruemock.Match("PTTL", "1")).Return([]rueidis.RedisResult{
+			ruemock.Result(ruemock.RedisString(rueidis.BinaryString(buf))),
+			ruemock.Result(ruemock.RedisInt64(-1)),
+		})
 		expectQuery(ctx, t, drv, "SELECT active FROM users", []any{true, false})
 
 		expected := entcache.Stats{Gets: 2, Hits: 1}
@@ -162,6 +168,40 @@ func TestDriver_Levels(t *testing.T) {
 			t.Errorf("unexpected stats: %v != %v", s, expected)
 		}
 	})
+
+	t.Run("Promote", func(t *testing.T) {
+		var (
+			ctx     = entcache.Cache(context.Background())
+			l1, l2  = entcache.NewLRU(0), entcache.NewLRU(0)
+			drv     = entcache.NewDriver(drv, entcache.Levels(l1, l2))
+			e       = &entcache.Entry{Columns: []string{"age"}, Values: [][]driver.Value{{20.1}}}
+			key, _  = entcache.DefaultHash("SELECT age FROM users", []any{})
+			key2, _ = entcache.DefaultHash("SELECT name FROM users", []any{})
+		)
+		if err := l2.Add(ctx, key, e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := l2.Add(ctx, key2, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		expectQuery(ctx, t, drv, "SELECT age FROM users", []any{20.1})
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{20.1})
+		// Hits of the second level are promoted with their remaining TTL.
+		_, ttl2, _ := l2.GetTTL(ctx, key)
+		_, ttl1, err := l1.GetTTL(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl1 <= 0 || ttl1 > ttl2 {
+			t.Fatalf("unexpected promoted TTL: %v > %v", ttl1, ttl2)
+		}
+		if _, ttl, err := l1.GetTTL(ctx, key2); err != nil || ttl != 0 {
+			t.Fatalf("unexpected promoted entry: %v %v", ttl, err)
+		}
+		if s := drv.Stats(); s.Hits != 2 {
+			t.Fatalf("unexpected stats: %v", s)
+		}
+	})
 }
 
 func TestDriver_ContextOptions(t *testing.T) {
@@ -420,7 +460,7 @@ func TestDriver_SkipInsert(t *testing.T) {
 	}
 }
 
-func expectQuery(ctx context.Context, t *testing.T, drv dialect.Driver, query string, args []any) {
+func expectQuery(ctx context.Context, t *testing.T, drv dialect.ExecQuerier, query string, args []any) {
 	rows := &sql.Rows{}
 	if err := drv.Query(ctx, query, []any{}, rows); err != nil {
 		t.Fatalf("unexpected query failure: %q: %v", query, err)
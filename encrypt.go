@@ -0,0 +1,257 @@
+package entcache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type (
+	// Encrypted wraps a cache level (e.g., Redis) and encrypts the entries before
+	// they are stored in it, using AES-GCM. Entries are serialized by a Codec, and
+	// the ciphertext is stored in the wrapped level as an entry with a single value.
+	//
+	// Each ciphertext is prefixed with the ID of the key that was used for encrypting
+	// it. New entries are encrypted with the active key, and entries encrypted with
+	// any of the other configured keys can still be decrypted. Hence, keys can be
+	// rotated by adding a new active key, and removing the old one after all entries
+	// that were encrypted with it expired.
+	//
+	// The cache key is used as additional authenticated data, and therefore, an entry
+	// cannot be moved to another key in the wrapped level without failing decryption.
+	Encrypted struct {
+		level  AddGetDeleter
+		codec  Codec
+		active string
+		keys   map[string]cipher.AEAD
+	}
+
+	// EncryptedOption allows configuring the Encrypted
+	// cache level using functional options.
+	EncryptedOption func(*Encrypted)
+)
+
+// encryptedColumn is the column of the entries that hold a ciphertext.
+const encryptedColumn = "entcache:encrypted"
+
+// ErrUnknownKey is returned by Get when the entry was encrypted with a key
+// that is not configured in the Encrypted level. Such entries are reported
+// as misses, and the error matches ErrNotFound as well.
+var ErrUnknownKey = errors.New("entcache: entry was encrypted with an unknown key")
+
+// NewEncrypted returns a new Encrypted level that wraps the given level. The keys
+// map key IDs to AES keys (16, 24 or 32 bytes long), and the active key ID selects
+// the key that is used for encrypting new entries.
+//
+//	redis, err := entcache.NewEncrypted(
+//		entcache.NewRedis(rdb),
+//		"2025-06",
+//		map[string][]byte{
+//			"2025-01": oldKey,
+//			"2025-06": newKey,
+//		},
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	drv := entcache.NewDriver(
+//		db,
+//		entcache.Levels(entcache.NewLRU(256), redis),
+//	)
+func NewEncrypted(level AddGetDeleter, active string, keys map[string][]byte, opts ...EncryptedOption) (*Encrypted, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("entcache: active key %q was not found", active)
+	}
+	e := &Encrypted{
+		level:  level,
+		codec:  GobCodec,
+		active: active,
+		keys:   make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("entcache: invalid key id length: %d", len(id))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("entcache: key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("entcache: key %q: %w", id, err)
+		}
+		e.keys[id] = aead
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e, nil
+}
+
+// EncryptedCodec configures the codec that is used for serializing
+// the entries before they are encrypted. The default is GobCodec.
+func EncryptedCodec(c Codec) EncryptedOption {
+	return func(e *Encrypted) {
+		e.codec = c
+	}
+}
+
+// Add encrypts the entry and adds it to the wrapped level.
+func (e *Encrypted) Add(ctx context.Context, k Key, ent *Entry, ttl time.Duration) error {
+	sealed, err := e.seal(k, ent)
+	if err != nil {
+		return err
+	}
+	return e.level.Add(ctx, k, sealed, ttl)
+}
+
+// seal encrypts the entry, and returns the entry that holds its ciphertext.
+func (e *Encrypted) seal(k Key, ent *Entry) (*Entry, error) {
+	buf, err := encodeEntry(e.codec, ent)
+	if err != nil {
+		return nil, err
+	}
+	aead := e.keys[e.active]
+	// Ciphertext format: len(id) | id | nonce | sealed data.
+	dst := make([]byte, 0, 1+len(e.active)+aead.NonceSize()+len(buf)+aead.Overhead())
+	dst = append(dst, byte(len(e.active)))
+	dst = append(dst, e.active...)
+	nonce := dst[len(dst) : len(dst)+aead.NonceSize()]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = aead.Seal(dst[:len(dst)+len(nonce)], nonce, buf, []byte(fmt.Sprint(k)))
+	return &Entry{Columns: []string{encryptedColumn}, Values: [][]driver.Value{{dst}}}, nil
+}
+
+// Get gets an entry from the wrapped level and decrypts it.
+func (e *Encrypted) Get(ctx context.Context, k Key) (*Entry, error) {
+	ent, err := e.level.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(k, ent)
+}
+
+// GetTTL gets an entry from the wrapped level and its remaining TTL, if the level
+// supports it, and decrypts the entry.
+func (e *Encrypted) GetTTL(ctx context.Context, k Key) (*Entry, time.Duration, error) {
+	t, ok := e.level.(TTLGetter)
+	if !ok {
+		ent, err := e.Get(ctx, k)
+		return ent, -1, err
+	}
+	ent, ttl, err := t.GetTTL(ctx, k)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ent, err = e.open(k, ent); err != nil {
+		return nil, 0, err
+	}
+	return ent, ttl, nil
+}
+
+// open decrypts the entry that holds the ciphertext.
+func (e *Encrypted) open(k Key, ent *Entry) (*Entry, error) {
+	if len(ent.Columns) != 1 || ent.Columns[0] != encryptedColumn || len(ent.Values) != 1 || len(ent.Values[0]) != 1 {
+		return nil, miss(fmt.Errorf("%w: entry is not encrypted", ErrInvalidEntry))
+	}
+	data, ok := ent.Values[0][0].([]byte)
+	if !ok || len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, miss(fmt.Errorf("%w: malformed encrypted entry", ErrInvalidEntry))
+	}
+	id := string(data[1 : 1+data[0]])
+	aead, ok := e.keys[id]
+	if !ok {
+		return nil, miss(fmt.Errorf("%w: %q", ErrUnknownKey, id))
+	}
+	data = data[1+len(id):]
+	if len(data) < aead.NonceSize() {
+		return nil, miss(fmt.Errorf("%w: malformed encrypted entry", ErrInvalidEntry))
+	}
+	buf, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(fmt.Sprint(k)))
+	if err != nil {
+		return nil, miss(fmt.Errorf("%w: decrypt: %w", ErrInvalidEntry, err))
+	}
+	out, err := decodeEntry(e.codec, buf)
+	if err != nil {
+		return nil, miss(err)
+	}
+	return out, nil
+}
+
+// Del deletes the entry from the wrapped level.
+func (e *Encrypted) Del(ctx context.Context, k Key) error {
+	return e.level.Del(ctx, k)
+}
+
+// Lease returns a lease token for the given key, if the wrapped level supports leases.
+func (e *Encrypted) Lease(ctx context.Context, k Key, ttl time.Duration) (string, error) {
+	if l, ok := e.level.(Leaser); ok {
+		return l.Lease(ctx, k, ttl)
+	}
+	return "", nil
+}
+
+// AddLease encrypts the entry and adds it to the wrapped level, using
+// the lease token if it supports leases.
+func (e *Encrypted) AddLease(ctx context.Context, k Key, token string, ent *Entry, ttl time.Duration) error {
+	l, ok := e.level.(Leaser)
+	if !ok {
+		return e.Add(ctx, k, ent, ttl)
+	}
+	sealed, err := e.seal(k, ent)
+	if err != nil {
+		return err
+	}
+	return l.AddLease(ctx, k, token, sealed, ttl)
+}
+
+// Tag tags the entry in the wrapped level, if it supports tags.
+func (e *Encrypted) Tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	t, ok := e.level.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.Tag(ctx, k, tags, ttl)
+}
+
+// InvalidateTags invalidates the given tags in the wrapped level, if it supports tags.
+func (e *Encrypted) InvalidateTags(ctx context.Context, tags ...string) error {
+	t, ok := e.level.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.InvalidateTags(ctx, tags...)
+}
+
+// Lock acquires the lock in the wrapped level, if it supports locks.
+func (e *Encrypted) Lock(ctx context.Context, k Key, ttl time.Duration) (string, bool, error) {
+	l, ok := e.level.(Locker)
+	if !ok {
+		return "", false, errNoLocker
+	}
+	return l.Lock(ctx, k, ttl)
+}
+
+// Unlock releases the lock in the wrapped level, if it supports locks.
+func (e *Encrypted) Unlock(ctx context.Context, k Key, token string) error {
+	l, ok := e.level.(Locker)
+	if !ok {
+		return errNoLocker
+	}
+	return l.Unlock(ctx, k, token)
+}
+
+// Size returns the size of the wrapped level, if it supports it.
+func (e *Encrypted) Size() int64 {
+	if s, ok := e.level.(Sizer); ok {
+		return s.Size()
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package entcache_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	e := &entcache.Entry{Columns: []string{"email"}, Values: [][]driver.Value{{"a8m@example.com"}}}
+
+	t.Run("AddGetDel", func(t *testing.T) {
+		inner := entcache.NewLRU(0)
+		enc, err := entcache.NewEncrypted(inner, "v1", map[string][]byte{"v1": oldKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := inner.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf := stored.Values[0][0].([]byte); bytes.Contains(buf, []byte("a8m")) || !bytes.HasPrefix(buf, []byte("\x02v1")) {
+			t.Fatalf("unexpected stored entry: %q", buf)
+		}
+		got, err := enc.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Columns[0] != "email" || got.Values[0][0] != "a8m@example.com" {
+			t.Fatalf("unexpected entry: %v", got)
+		}
+		if err := enc.Del(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := enc.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Promote", func(t *testing.T) {
+		l1 := entcache.NewLRU(0)
+		enc, err := entcache.NewEncrypted(entcache.NewLRU(0), "v1", map[string][]byte{"v1": oldKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		drv := entcache.NewDriver(nil, entcache.Levels(l1, enc))
+		if err := enc.Add(ctx, 1, e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := drv.Cache.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		// Hits are promoted decrypted, with their remaining TTL.
+		got, ttl, err := l1.GetTTL(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Values[0][0] != "a8m@example.com" || ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("unexpected promoted entry: %v %v", got, ttl)
+		}
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		inner := entcache.NewLRU(0)
+		v1, err := entcache.NewEncrypted(inner, "v1", map[string][]byte{"v1": oldKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v1.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		v2, err := entcache.NewEncrypted(inner, "v2", map[string][]byte{"v1": oldKey, "v2": newKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v2.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := v2.Add(ctx, 2, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v1.Get(ctx, 2); !errors.Is(err, entcache.ErrUnknownKey) {
+			t.Fatalf("expected ErrUnknownKey, got: %v", err)
+		}
+		if _, err := v2.Get(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		inner := entcache.NewLRU(0)
+		enc, err := entcache.NewEncrypted(inner, "v1", map[string][]byte{"v1": oldKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := inner.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Entries cannot be moved to another key.
+		if err := inner.Add(ctx, 2, stored, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := enc.Get(ctx, 2); err == nil {
+			t.Fatal("expected decryption error")
+		}
+		if err := inner.Add(ctx, 3, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := enc.Get(ctx, 3); err == nil {
+			t.Fatal("expected error for plaintext entry")
+		}
+	})
+
+	t.Run("InvalidKeys", func(t *testing.T) {
+		if _, err := entcache.NewEncrypted(entcache.NewLRU(0), "v2", map[string][]byte{"v1": oldKey}); err == nil {
+			t.Fatal("expected error for missing active key")
+		}
+		if _, err := entcache.NewEncrypted(entcache.NewLRU(0), "v1", map[string][]byte{"v1": []byte("short")}); err == nil {
+			t.Fatal("expected error for invalid key size")
+		}
+	})
+}
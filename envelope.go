@@ -0,0 +1,143 @@
+package entcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Serialized entries are wrapped in a versioned envelope, that allows readers to
+// detect entries that were written by other applications, by older (or newer)
+// versions of this package, or were corrupted:
+//
+//	magic (2 bytes) | version (1 byte) | len(codec) (1 byte) | codec | crc32c(payload) (4 bytes) | payload
+//
+// The codec is identified by its name, and hence, entries that were written
+// with another registered codec (e.g., during a codec swap) are decoded too.
+const (
+	envelopeVersion = 1
+	envelopeMagic   = "\xec\xac"
+)
+
+// ErrInvalidEntry is returned when decoding serialized entries that were not
+// written by this package, were written with an unsupported format version or
+// an unknown codec, or were corrupted. Cache levels report such entries as
+// misses, and their errors match both ErrNotFound and ErrInvalidEntry.
+var ErrInvalidEntry = errors.New("entcache: invalid entry encoding")
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+	codecs   = struct {
+		sync.RWMutex
+		m map[string]Codec
+	}{
+		m: map[string]Codec{
+			GobCodec.Name():     GobCodec,
+			CBORCodec.Name():    CBORCodec,
+			MsgpackCodec.Name(): MsgpackCodec,
+			JSONCodec.Name():    JSONCodec,
+		},
+	}
+)
+
+// RegisterCodec registers a custom codec, so entries that were encoded by it
+// can be decoded by cache levels that are configured with another codec. The
+// builtin codecs, and their compressed variants, are registered by default.
+//
+// Registering the same codec twice is a no-op. An error is returned if another
+// codec is already registered with the same name, as entries are decoded by
+// the codec that is registered with their name.
+func RegisterCodec(c Codec) error {
+	name := c.Name()
+	if name == "" || len(name) > 255 {
+		return fmt.Errorf("entcache: invalid codec name length: %d", len(name))
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	if r, ok := codecs.m[name]; ok {
+		if t := reflect.TypeOf(c); t == reflect.TypeOf(r) && t.Comparable() && r == c {
+			return nil
+		}
+		return fmt.Errorf("entcache: codec %q is already registered", name)
+	}
+	codecs.m[name] = c
+	return nil
+}
+
+// lookupCodec returns the codec with the given name.
+func lookupCodec(name string) (Codec, bool) {
+	codecs.RLock()
+	c, ok := codecs.m[name]
+	codecs.RUnlock()
+	if ok {
+		return c, true
+	}
+	// Compressed codecs are decoded regardless of their algorithm.
+	if i := strings.LastIndexByte(name, '+'); i > 0 {
+		if c, ok := lookupCodec(name[:i]); ok {
+			return Compress(c, CompressionNone, 0), true
+		}
+	}
+	return nil, false
+}
+
+// encodeEntry encodes the entry using the given codec, and wraps it in an envelope.
+func encodeEntry(c Codec, e *Entry) ([]byte, error) {
+	name := c.Name()
+	if name == "" || len(name) > 255 {
+		return nil, fmt.Errorf("entcache: invalid codec name length: %d", len(name))
+	}
+	payload, err := c.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(envelopeMagic)+2+len(name)+4+len(payload))
+	buf = append(buf, envelopeMagic...)
+	buf = append(buf, envelopeVersion, byte(len(name)))
+	buf = append(buf, name...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	return append(buf, payload...), nil
+}
+
+// decodeEntry unwraps the envelope, and decodes the entry using the codec it
+// was encoded with. The given codec is used if its name matches, as it may not
+// be registered. Errors returned by decodeEntry match ErrInvalidEntry.
+func decodeEntry(c Codec, buf []byte) (*Entry, error) {
+	if len(buf) < len(envelopeMagic)+2 || string(buf[:len(envelopeMagic)]) != envelopeMagic {
+		return nil, fmt.Errorf("%w: missing envelope", ErrInvalidEntry)
+	}
+	buf = buf[len(envelopeMagic):]
+	if v := buf[0]; v != envelopeVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidEntry, v)
+	}
+	n := int(buf[1])
+	buf = buf[2:]
+	if len(buf) < n+4 {
+		return nil, fmt.Errorf("%w: truncated envelope", ErrInvalidEntry)
+	}
+	name := string(buf[:n])
+	if c == nil || c.Name() != name {
+		var ok bool
+		if c, ok = lookupCodec(name); !ok {
+			return nil, fmt.Errorf("%w: unknown codec %q", ErrInvalidEntry, name)
+		}
+	}
+	sum, payload := binary.BigEndian.Uint32(buf[n:]), buf[n+4:]
+	if crc32.Checksum(payload, crcTable) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidEntry)
+	}
+	e := &Entry{}
+	if err := c.Unmarshal(payload, e); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEntry, err)
+	}
+	return e, nil
+}
+
+// miss reports a decoding error as a cache miss.
+func miss(err error) error {
+	return fmt.Errorf("%w: %w", ErrNotFound, err)
+}
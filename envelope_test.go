@@ -0,0 +1,191 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/rueidis"
+	ruemock "github.com/redis/rueidis/mock"
+	"go.uber.org/mock/gomock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	expectMiss := func(t *testing.T, c entcache.AddGetDeleter, k entcache.Key) {
+		t.Helper()
+		_, err := c.Get(ctx, k)
+		if !errors.Is(err, entcache.ErrNotFound) || !errors.Is(err, entcache.ErrInvalidEntry) {
+			t.Fatalf("expected invalid entry to be reported as miss, got: %v", err)
+		}
+	}
+
+	t.Run("MarshalBinary", func(t *testing.T) {
+		buf, err := e.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:5]) != "\xec\xac\x01\x03g" {
+			t.Fatalf("unexpected envelope: %q", buf)
+		}
+		got := &entcache.Entry{}
+		if err := got.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+		if got.Values[0][0] != "a8m" {
+			t.Fatalf("unexpected entry: %v", got)
+		}
+		legacy, err := entcache.GobCodec.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := got.UnmarshalBinary(legacy); !errors.Is(err, entcache.ErrInvalidEntry) {
+			t.Fatalf("expected ErrInvalidEntry, got: %v", err)
+		}
+	})
+
+	t.Run("CodecSwap", func(t *testing.T) {
+		srv := newFakeMemcached(t)
+		writer := entcache.NewMemcache(srv.addr(), entcache.MemcacheCodec(entcache.Compress(entcache.CBORCodec, entcache.CompressionZstd, 1)))
+		defer writer.Close()
+		reader := entcache.NewMemcache(srv.addr(), entcache.MemcacheCodec(entcache.JSONCodec))
+		defer reader.Close()
+		if err := writer.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		got, err := reader.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Values[0][0] != "a8m" {
+			t.Fatalf("unexpected entry: %v", got)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		srv := newFakeMemcached(t)
+		m := entcache.NewMemcache(srv.addr())
+		defer m.Close()
+		set := func(k string, v []byte) {
+			srv.mu.Lock()
+			srv.items[k] = fakeItem{value: v, flags: "0"}
+			srv.mu.Unlock()
+		}
+		legacy, err := entcache.GobCodec.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set("legacy", legacy)
+		expectMiss(t, m, "legacy")
+
+		buf, err := e.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt := append([]byte(nil), buf...)
+		corrupt[len(corrupt)-1] ^= 0xff
+		set("corrupt", corrupt)
+		expectMiss(t, m, "corrupt")
+
+		version := append([]byte(nil), buf...)
+		version[2] = 2
+		set("version", version)
+		expectMiss(t, m, "version")
+
+		set("truncated", buf[:8])
+		expectMiss(t, m, "truncated")
+	})
+
+	t.Run("RegisterCodec", func(t *testing.T) {
+		srv := newFakeMemcached(t)
+		reader := entcache.NewMemcache(srv.addr())
+		defer reader.Close()
+		// Entries of unregistered codecs are misses.
+		unregistered := entcache.NewMemcache(srv.addr(), entcache.MemcacheCodec(upperCodec{name: "unregistered"}))
+		defer unregistered.Close()
+		if err := unregistered.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := unregistered.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		expectMiss(t, reader, 1)
+		// The registry is global, and registering
+		// the same codec again (e.g., -count=2) is a no-op.
+		c := upperCodec{name: "upper"}
+		if err := entcache.RegisterCodec(c); err != nil {
+			t.Fatal(err)
+		}
+		if err := entcache.RegisterCodec(c); err != nil {
+			t.Fatal(err)
+		}
+		writer := entcache.NewMemcache(srv.addr(), entcache.MemcacheCodec(c))
+		defer writer.Close()
+		if err := writer.Add(ctx, 2, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.Get(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+		// Other codecs cannot replace registered ones.
+		for _, name := range []string{"upper", "gob"} {
+			if err := entcache.RegisterCodec(upperCodec{name: name, id: 1}); err == nil {
+				t.Fatalf("expected duplicate codec %q to be rejected", name)
+			}
+		}
+		if err := entcache.RegisterCodec(upperCodec{}); err == nil {
+			t.Fatal("expected codec without a name to be rejected")
+		}
+	})
+}
+
+func TestDriver_InvalidEntry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		rdb = ruemock.NewClient(gomock.NewController(t))
+		drv = entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.Levels(entcache.NewRedis(rdb)),
+			entcache.Hash(func(string, []any) (entcache.Key, error) {
+				return 1, nil
+			}),
+		)
+		ctx = entcache.Cache(context.Background())
+	)
+	rdb.EXPECT().Do(ctx, ruemock.Match("GET", "1")).Return(ruemock.Result(ruemock.RedisString("foreign")))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	buf, err := entcache.Entry{Columns: []string{"column_0"}, Values: [][]driver.Value{{"a8m"}}}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb.EXPECT().Do(ctx, ruemock.Match("SET", "1", rueidis.BinaryString(buf), "EX", "0")).Return(ruemock.Result(ruemock.RedisNil()))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// upperCodec is a custom codec that is not registered by default.
+type upperCodec struct {
+	name string
+	id   int // distinguishes codecs with the same name
+}
+
+func (c upperCodec) Name() string { return c.name }
+
+func (upperCodec) Marshal(e *entcache.Entry) ([]byte, error) { return entcache.JSONCodec.Marshal(e) }
+
+func (upperCodec) Unmarshal(buf []byte, e *entcache.Entry) error {
+	return entcache.JSONCodec.Unmarshal(buf, e)
+}
@@ -0,0 +1,160 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/rueidis"
+	ruemock "github.com/redis/rueidis/mock"
+	"go.uber.org/mock/gomock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+// failing is a cache level that fails all operations.
+type failing struct {
+	calls atomic.Int64
+}
+
+var errUnavailable = errors.New("cache is unavailable")
+
+func (f *failing) Add(context.Context, entcache.Key, *entcache.Entry, time.Duration) error {
+	f.calls.Add(1)
+	return errUnavailable
+}
+
+func (f *failing) Get(context.Context, entcache.Key) (*entcache.Entry, error) {
+	f.calls.Add(1)
+	return nil, errUnavailable
+}
+
+func (f *failing) Del(context.Context, entcache.Key) error {
+	f.calls.Add(1)
+	return errUnavailable
+}
+
+func TestDriver_FaultTolerant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := entcache.Cache(context.Background())
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+
+	t.Run("Query", func(t *testing.T) {
+		lru, remote := entcache.NewLRU(0), &failing{}
+		drv := entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.FaultTolerant(time.Minute),
+			entcache.Levels(lru, remote),
+			entcache.Hash(func(string, []any) (entcache.Key, error) {
+				return 1, nil
+			}),
+		)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		// The failed get was treated as a miss, and the
+		// entry was stored in the healthy level.
+		if _, err := lru.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if s := drv.LevelStats(); len(s) != 2 || s[0] != (entcache.LevelStats{}) || s[1] != (entcache.LevelStats{Errors: 1, Unhealthy: true}) {
+			t.Fatalf("unexpected level stats: %v", s)
+		}
+		// Unhealthy levels are skipped, except for deletions.
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		if err := drv.Cache.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if n := remote.calls.Load(); n != 1 {
+			t.Fatalf("expected unhealthy level to be skipped, got %d calls", n)
+		}
+		if err := drv.Cache.Del(ctx, 1); !errors.Is(err, errUnavailable) {
+			t.Fatalf("expected joined error, got: %v", err)
+		}
+		if _, err := lru.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected entry to be deleted, got: %v", err)
+		}
+		if s := drv.LevelStats(); s[1].Errors != 2 {
+			t.Fatalf("unexpected level stats: %v", s)
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		lru, remote := entcache.NewLRU(0), &failing{}
+		drv := entcache.NewDriver(nil, entcache.Levels(remote, lru), entcache.FaultTolerant(0))
+		if _, err := drv.Cache.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) || !errors.Is(err, errUnavailable) {
+			t.Fatalf("expected joined ErrNotFound, got: %v", err)
+		}
+		if err := lru.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := drv.Cache.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		// Without backoff, failing levels are not skipped.
+		if s := drv.LevelStats(); s[0] != (entcache.LevelStats{Errors: 2}) {
+			t.Fatalf("unexpected level stats: %v", s)
+		}
+	})
+
+	t.Run("Redis", func(t *testing.T) {
+		rdb := ruemock.NewClient(gomock.NewController(t))
+		refused := errors.New("dial tcp: connection refused")
+		rdb.EXPECT().DoMulti(gomock.Any(), ruemock.Match("GET", "1"), ruemock.Match("PTTL", "1")).
+			Return([]rueidis.RedisResult{ruemock.ErrorResult(refused), ruemock.ErrorResult(refused)})
+		drv := entcache.NewDriver(nil, entcache.Levels(entcache.NewLRU(0), entcache.NewRedis(rdb)), entcache.FaultTolerant(time.Minute))
+		// Errors that are reported as misses mark the level as unhealthy.
+		if _, err := drv.Cache.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) || !errors.Is(err, refused) {
+			t.Fatalf("expected joined ErrNotFound, got: %v", err)
+		}
+		if s := drv.LevelStats(); s[1] != (entcache.LevelStats{Errors: 1, Unhealthy: true}) {
+			t.Fatalf("unexpected level stats: %v", s)
+		}
+		// The unhealthy level is skipped.
+		if _, err := drv.Cache.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		remote := &failing{}
+		drv := entcache.NewDriver(nil, entcache.Levels(entcache.NewLRU(0), remote), entcache.FaultTolerant(100*time.Millisecond))
+		_ = drv.Cache.Add(ctx, 1, e, 0)
+		_ = drv.Cache.Add(ctx, 1, e, 0)
+		time.Sleep(150 * time.Millisecond)
+		if s := drv.LevelStats(); s[1].Unhealthy {
+			t.Fatalf("expected level to be healthy after backoff: %v", s)
+		}
+		_ = drv.Cache.Add(ctx, 1, e, 0)
+		if n := remote.calls.Load(); n != 2 {
+			t.Fatalf("expected level to be probed after backoff, got %d calls", n)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		lru := entcache.NewLRU(0)
+		drv := entcache.NewDriver(nil, entcache.Levels(&failing{}, lru))
+		if err := drv.Cache.Add(ctx, 1, e, 0); !errors.Is(err, errUnavailable) {
+			t.Fatalf("expected error, got: %v", err)
+		}
+		if _, err := lru.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected Add to stop at the failing level, got: %v", err)
+		}
+		// Errors are not tracked without fault tolerance.
+		if s := drv.LevelStats(); len(s) != 2 || s[0].Errors != 0 || s[1].Errors != 0 {
+			t.Fatalf("unexpected level stats: %v", s)
+		}
+	})
+}
@@ -7,15 +7,21 @@ toolchain go1.24.2
 require (
 	entgo.io/ent v0.14.5
 	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/fxamacker/cbor/v2 v2.9.4
 	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8
+	github.com/klauspost/compress v1.18.2
 	github.com/mitchellh/hashstructure/v2 v2.0.2
 	github.com/redis/rueidis v1.0.68
 	github.com/redis/rueidis/mock v1.0.68
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	go.etcd.io/bbolt v1.4.3
 	go.uber.org/mock v0.6.0
 	golang.org/x/sync v0.18.0
 )
 
 require (
 	github.com/google/uuid v1.6.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 )
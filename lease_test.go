@@ -0,0 +1,229 @@
+package entcache_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/rueidis"
+	ruemock "github.com/redis/rueidis/mock"
+	"go.uber.org/mock/gomock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestLRU_Lease(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	// Wrapper levels forward leases to the wrapped level.
+	enc, err := entcache.NewEncrypted(entcache.NewLRU(0), "v1", map[string][]byte{"v1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := entcache.NewBroadcast(entcache.NewMemoryBus(), entcache.NewLRU(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	for _, l := range []interface {
+		entcache.AddGetDeleter
+		entcache.Leaser
+	}{entcache.NewLRU(0), entcache.NewShardedLRU(0, 0), enc, local} {
+		token, err := l.Lease(ctx, 1, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Concurrent misses share the lease.
+		if shared, _ := l.Lease(ctx, 1, time.Minute); shared != token {
+			t.Fatalf("expected shared lease: %q != %q", shared, token)
+		}
+		if err := l.AddLease(ctx, 1, token, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		// Leases are consumed.
+		if err := l.AddLease(ctx, 1, token, e, 0); !errors.Is(err, entcache.ErrLeaseRevoked) {
+			t.Fatalf("expected ErrLeaseRevoked, got: %v", err)
+		}
+
+		// Deletions revoke outstanding leases.
+		token, _ = l.Lease(ctx, 2, time.Minute)
+		if err := l.Del(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.AddLease(ctx, 2, token, e, 0); !errors.Is(err, entcache.ErrLeaseRevoked) {
+			t.Fatalf("expected ErrLeaseRevoked, got: %v", err)
+		}
+		if newToken, _ := l.Lease(ctx, 2, time.Minute); newToken == token {
+			t.Fatal("expected a new lease after revocation")
+		}
+
+		// Expired leases are rejected.
+		token, _ = l.Lease(ctx, 3, time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		if err := l.AddLease(ctx, 3, token, e, 0); !errors.Is(err, entcache.ErrLeaseRevoked) {
+			t.Fatalf("expected ErrLeaseRevoked, got: %v", err)
+		}
+		if _, err := l.Get(ctx, 3); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+	}
+}
+
+func TestRedis_Lease(t *testing.T) {
+	var (
+		ctx = context.Background()
+		rdb = ruemock.NewClient(gomock.NewController(t))
+		r   = entcache.NewRedis(rdb)
+		e   = &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+	)
+	rdb.EXPECT().Do(ctx, ruemock.MatchFn(func(cmd []string) bool {
+		return cmd[0] == "EVAL" && cmd[2] == "1" && cmd[3] == "entcache:lease:{1}" && cmd[5] == "60000"
+	})).Return(ruemock.Result(ruemock.RedisString("token")))
+	token, err := r.Lease(ctx, 1, time.Minute)
+	if err != nil || token != "token" {
+		t.Fatalf("unexpected lease: %q %v", token, err)
+	}
+
+	buf, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addLease := func(cmd []string) bool {
+		return cmd[0] == "EVAL" && cmd[2] == "2" && cmd[3] == "entcache:lease:{1}" && cmd[4] == "1" &&
+			cmd[5] == "token" && cmd[6] == rueidis.BinaryString(buf) && cmd[7] == "0"
+	}
+	rdb.EXPECT().Do(ctx, ruemock.MatchFn(addLease)).Return(ruemock.Result(ruemock.RedisInt64(1)))
+	if err := r.AddLease(ctx, 1, token, e, 0); err != nil {
+		t.Fatal(err)
+	}
+	rdb.EXPECT().Do(ctx, ruemock.MatchFn(addLease)).Return(ruemock.Result(ruemock.RedisInt64(0)))
+	if err := r.AddLease(ctx, 1, token, e, 0); !errors.Is(err, entcache.ErrLeaseRevoked) {
+		t.Fatalf("expected ErrLeaseRevoked, got: %v", err)
+	}
+
+	rdb.EXPECT().Do(ctx, ruemock.Match("DEL", "1", "entcache:lease:{1}")).Return(ruemock.Result(ruemock.RedisInt64(1)))
+	if err := r.Del(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriver_Lease(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := entcache.Cache(context.Background())
+	for _, lease := range []bool{true, false} {
+		level := entcache.NewLRU(0)
+		opts := []entcache.Option{
+			entcache.Levels(entcache.NewLRU(0), level),
+			entcache.Hash(func(string, []any) (entcache.Key, error) {
+				return 1, nil
+			}),
+		}
+		if lease {
+			opts = append(opts, entcache.WithLease(time.Minute))
+		}
+		drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), opts...)
+		// The entry is invalidated while the query is executed.
+		mock.ExpectQuery("SELECT name FROM users").
+			WillDelayFor(30 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_ = level.Del(ctx, 1)
+		}()
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := drv.Cache.Get(ctx, 1)
+		if lease && !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected stale entry to be rejected, got: %v", err)
+		}
+		if !lease && err != nil {
+			t.Fatalf("expected entry to be stored, got: %v", err)
+		}
+	}
+}
+
+func TestDriver_LeaseTableInvalidation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.WithTableInvalidation(true),
+		entcache.WithLease(time.Minute),
+		entcache.Hash(func(string, []any) (entcache.Key, error) {
+			return 1, nil
+		}),
+	)
+	ctx := entcache.Cache(context.Background())
+	// The table is modified through the driver while the query is executed.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	mock.ExpectExec("UPDATE users SET name").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done <- drv.Exec(ctx, "UPDATE users SET name = ?", []any{"nati"}, nil)
+	}()
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := drv.Cache.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected stale entry to be rejected, got: %v", err)
+	}
+}
+
+func TestDriver_LeaseTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.WithLease(time.Minute),
+		entcache.Hash(func(string, []any) (entcache.Key, error) {
+			return 1, nil
+		}),
+	)
+	ctx := entcache.Cache(context.Background(), entcache.WithTags("user:1"))
+	// The tag is invalidated while the query is executed.
+	mock.ExpectQuery("SELECT name FROM users").
+		WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done <- drv.InvalidateTags(ctx, "user:1")
+	}()
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := drv.Cache.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+		t.Fatalf("expected stale entry to be rejected, got: %v", err)
+	}
+}
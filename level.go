@@ -1,11 +1,12 @@
 package entcache
 
 import (
-	"bytes"
 	"context"
 	"database/sql/driver"
-	"encoding/gob"
 	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,96 +22,516 @@ type (
 		Add(context.Context, Key, *Entry, time.Duration) error
 		Get(context.Context, Key) (*Entry, error)
 	}
+
+	// Tagger is an optional interface implemented by cache levels
+	// that support grouping entries by tags and invalidating them
+	// all at once.
+	Tagger interface {
+		// Tag associates the entry stored under the given key with the given
+		// tags. The TTL is the TTL of the entry, and may be used by the cache
+		// level for expiring its tag index.
+		Tag(context.Context, Key, []string, time.Duration) error
+		// InvalidateTags deletes all entries that are associated with
+		// at least one of the given tags.
+		InvalidateTags(context.Context, ...string) error
+	}
+
+	// Sizer is an optional interface implemented by cache levels
+	// that track the size of their stored entries in bytes.
+	Sizer interface {
+		Size() int64
+	}
+
+	// Locker is an optional interface implemented by cache levels that are
+	// shared between processes, and support acquiring short-lived locks on
+	// keys. It is used by the driver for coalescing cache misses across
+	// processes (see WithDistributedLock).
+	Locker interface {
+		// Lock tries to acquire the lock of the given key for the given
+		// period, and returns its token if it was acquired.
+		Lock(context.Context, Key, time.Duration) (token string, ok bool, err error)
+		// Unlock releases the lock of the given key, if it is still
+		// held by the given token.
+		Unlock(context.Context, Key, string) error
+	}
+
+	// TTLGetter is an optional interface implemented by cache levels that
+	// report the remaining TTL of their entries. It is used by multi-level
+	// caches for promoting hits of slower levels to the faster ones.
+	TTLGetter interface {
+		// GetTTL gets an entry from the cache, and its remaining TTL. Zero
+		// means the entry does not expire, and a negative TTL means that it
+		// is unknown.
+		GetTTL(context.Context, Key) (*Entry, time.Duration, error)
+	}
+
+	// Leaser is an optional interface implemented by cache levels that support
+	// leases, which prevent storing entries that were read before their key was
+	// invalidated. A lease is obtained on a miss before the query is executed,
+	// and deleting the key (e.g., by Del or InvalidateTags) revokes all of its
+	// outstanding leases.
+	Leaser interface {
+		// Lease returns a lease token for the given key, that is valid for
+		// the given period, unless the key is deleted.
+		Lease(context.Context, Key, time.Duration) (string, error)
+		// AddLease adds the entry to the cache if the given lease token is
+		// still valid, and fails with ErrLeaseRevoked otherwise. The lease
+		// is consumed by a successful call.
+		AddLease(context.Context, Key, string, *Entry, time.Duration) error
+	}
 )
 
 type Entry struct {
 	Columns []string         `cbor:"0,keyasint" json:"c" bson:"c"`
 	Values  [][]driver.Value `cbor:"1,keyasint" json:"v" bson:"v"`
+	// StaleAt is the soft expiry of the entry, after which it is served
+	// stale while it is revalidated. The hard expiry of the entry is the
+	// TTL of the cache level. Zero means the entry does not become stale.
+	StaleAt time.Time `cbor:"2,keyasint,omitempty" json:"s,omitempty" bson:"s,omitempty"`
+	// CreatedAt and Delta are the creation time of the entry, and the
+	// time it took to compute it. They are recorded in XFetch mode.
+	CreatedAt time.Time     `cbor:"3,keyasint,omitempty" json:"t,omitempty" bson:"t,omitempty"`
+	Delta     time.Duration `cbor:"4,keyasint,omitempty" json:"d,omitempty" bson:"d,omitempty"`
+	// Tags are the tags of the entry (see WithTags). They are
+	// applied to the faster levels the entry is promoted to.
+	Tags []string `cbor:"5,keyasint,omitempty" json:"g,omitempty" bson:"g,omitempty"`
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
+// The entry is encoded by the GobCodec, and wrapped in an envelope.
 //
 //goland:noinspection GoMixedReceiverTypes
 func (e Entry) MarshalBinary() ([]byte, error) {
-	entry := struct {
-		C []string
-		V [][]driver.Value
-	}{
-		C: e.Columns,
-		V: e.Values,
-	}
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+	return encodeEntry(GobCodec, &e)
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// The data may be encoded by any registered codec.
 //
 //goland:noinspection GoMixedReceiverTypes
 func (e *Entry) UnmarshalBinary(buf []byte) error {
-	var entry struct {
-		C []string
-		V [][]driver.Value
-	}
-	if err := gob.NewDecoder(bytes.NewBuffer(buf)).Decode(&entry); err != nil {
+	ne, err := decodeEntry(GobCodec, buf)
+	if err != nil {
 		return err
 	}
-	e.Values = entry.V
-	e.Columns = entry.C
+	*e = *ne
 	return nil
 }
 
 // ErrNotFound returned by Get when and Entry does not exist in the cache.
 var ErrNotFound = errors.New("entcache: entry was not found")
 
+// ErrLeaseRevoked is returned by AddLease when the lease token is no
+// longer valid, because the key was deleted or the lease expired.
+var ErrLeaseRevoked = errors.New("entcache: lease was revoked")
+
+// unavailableError wraps an error of a cache level that failed to serve a get (e.g.,
+// a network error), and is reported as a miss. Unlike misses (and entries that fail
+// to decode), it is counted as a failure of the level by the Breaker and by fault
+// tolerance (see FaultTolerant).
+type unavailableError struct {
+	err error
+}
+
+// unavailable reports the error of a cache level as a miss.
+func unavailable(err error) error {
+	return &unavailableError{err: err}
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrNotFound, e.err)
+}
+
+func (e *unavailableError) Unwrap() []error {
+	return []error{ErrNotFound, e.err}
+}
+
+// isUnavailable reports if the error was reported by unavailable.
+func isUnavailable(err error) bool {
+	var ue *unavailableError
+	return errors.As(err, &ue)
+}
+
+// errNoTagger is returned when tags are used with a cache that does not support them.
+var errNoTagger = errors.New("entcache: cache does not support tags")
+
+// errNoLocker is returned when locks are used with a cache that does not support them.
+var errNoLocker = errors.New("entcache: cache does not support locks")
+
 type (
-	// entry wraps the Entry with additional expiry information.
+	// entry wraps the Entry with additional expiry and size information.
 	entry struct {
 		*Entry
 		expiry time.Time
+		size   int64
 	}
 )
 
 // multiLevel provides a multi-level cache implementation.
 type multiLevel struct {
 	levels []AddGetDeleter
+	// health of the levels, if fault tolerance is enabled.
+	health  []levelHealth
+	backoff time.Duration
 }
 
-// Add adds the entry to the cache.
+// levelHealth tracks the failures of a cache level.
+type levelHealth struct {
+	errors atomic.Uint64
+	// unix time (in nanoseconds) until the level is skipped.
+	until atomic.Int64
+}
+
+// LevelStats represent the statistics of a cache level in a multi-level
+// cache. Errors are tracked if fault tolerance is enabled (see FaultTolerant).
+type LevelStats struct {
+	Errors    uint64 // Number of failed operations
+	Unhealthy bool   // The level is skipped until its backoff window ends
+	Bytes     int64  // Approximate size of the level's entries, reported by levels that implement Sizer
+}
+
+// tolerant enables fault tolerance, and marks failing
+// levels as unhealthy for the given backoff window.
+func (m *multiLevel) tolerant(backoff time.Duration) {
+	m.health = make([]levelHealth, len(m.levels))
+	m.backoff = backoff
+}
+
+// skip reports if the given level is unhealthy, and should be skipped.
+func (m *multiLevel) skip(i int) bool {
+	return m.health != nil && time.Now().UnixNano() < m.health[i].until.Load()
+}
+
+// fail records the failure of the given level and marks it as unhealthy,
+// unless it was caused by the entry itself. It reports if the error should
+// be tolerated, i.e. fault tolerance is enabled.
+func (m *multiLevel) fail(i int, err error) bool {
+	if m.health == nil {
+		return false
+	}
+	if !errors.Is(err, ErrEntryTooLarge) && !errors.Is(err, ErrLeaseRevoked) {
+		m.health[i].errors.Add(1)
+		if m.backoff > 0 {
+			m.health[i].until.Store(time.Now().Add(m.backoff).UnixNano())
+		}
+	}
+	return true
+}
+
+// stats returns the statistics of the levels.
+func (m *multiLevel) stats() []LevelStats {
+	stats := make([]LevelStats, len(m.levels))
+	for i := range m.levels {
+		if m.health != nil {
+			stats[i] = LevelStats{Errors: m.health[i].errors.Load(), Unhealthy: m.skip(i)}
+		}
+		if s, ok := m.levels[i].(Sizer); ok {
+			stats[i].Bytes = s.Size()
+		}
+	}
+	return stats
+}
+
+// Add adds the entry to the cache. If fault tolerance is enabled, unhealthy
+// levels are skipped, and the entry is added to all other levels even if some
+// of them fail. Their errors are joined and returned.
 func (m *multiLevel) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	var errs []error
 	for i := range m.levels {
+		if m.skip(i) {
+			continue
+		}
 		if err := m.levels[i].Add(ctx, k, e, ttl); err != nil {
-			return err
+			if !m.fail(i, err) {
+				return err
+			}
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
-// Get gets an entry from the cache.
+// Get gets an entry from the cache. Hits of slower levels that report the
+// remaining TTL of their entries (see TTLGetter) are promoted to the faster
+// levels with this TTL, so they never outlive the entry of the slower level.
+//
+// If fault tolerance is enabled, unhealthy levels are skipped, and failing
+// levels are treated as misses. Their errors are joined with ErrNotFound if
+// the entry was not found in other levels.
 func (m *multiLevel) Get(ctx context.Context, k Key) (*Entry, error) {
+	var errs []error
 	for i := range m.levels {
-		switch e, err := m.levels[i].Get(ctx, k); {
+		if m.skip(i) {
+			continue
+		}
+		e, ttl, err := m.get(ctx, i, k)
+		switch {
 		case err == nil:
+			if ttl >= 0 {
+				m.promote(ctx, i, k, e, ttl)
+			}
 			return e, nil
-		case !errors.Is(err, ErrNotFound):
+		case isUnavailable(err):
+			// Errors that are reported as misses are
+			// recorded, but are not returned otherwise.
+			if m.fail(i, err) {
+				errs = append(errs, err)
+			}
+		case errors.Is(err, ErrNotFound):
+		case !m.fail(i, err):
 			return nil, err
+		default:
+			errs = append(errs, err)
 		}
 	}
+	if len(errs) > 0 {
+		return nil, errors.Join(append([]error{ErrNotFound}, errs...)...)
+	}
 	return nil, ErrNotFound
 }
 
-// Del deletes an entry from the cache.
+// get gets an entry from the given level, and its remaining TTL if it
+// needs to be promoted. A negative TTL means it should not be promoted.
+func (m *multiLevel) get(ctx context.Context, i int, k Key) (*Entry, time.Duration, error) {
+	if t, ok := m.levels[i].(TTLGetter); ok && i > 0 {
+		return t.GetTTL(ctx, k)
+	}
+	e, err := m.levels[i].Get(ctx, k)
+	return e, -1, err
+}
+
+// promote adds the entry to the levels that precede the given level. It is
+// best-effort, as the entry is served from the slower level in case of failure.
+// Tagged entries are tagged in the faster levels before they are added, and are
+// not promoted to levels that cannot be tagged, as they would not be invalidated
+// by their tags.
+func (m *multiLevel) promote(ctx context.Context, i int, k Key, e *Entry, ttl time.Duration) {
+	if ttl > 0 {
+		// Round down, so promoted entries do not outlive the source
+		// entry by the time it takes to promote them.
+		if ttl = ttl.Truncate(time.Millisecond); ttl == 0 {
+			return
+		}
+	}
+	for j := range i {
+		if m.skip(j) || len(e.Tags) > 0 && !tagPromoted(ctx, m.levels[j], k, e.Tags, ttl) {
+			continue
+		}
+		if l, ok := m.levels[j].(*Level); ok {
+			_ = l.promote(ctx, k, e, ttl)
+		} else {
+			_ = m.levels[j].Add(ctx, k, e, ttl)
+		}
+	}
+}
+
+// tagPromoted tags the promoted entry in the given level, and
+// reports if it was tagged.
+func tagPromoted(ctx context.Context, l AddGetDeleter, k Key, tags []string, ttl time.Duration) bool {
+	t, ok := l.(Tagger)
+	return ok && t.Tag(ctx, k, tags, ttl) == nil
+}
+
+// Del deletes an entry from the cache. If fault tolerance is enabled, the
+// entry is deleted from all levels, including unhealthy ones (as otherwise,
+// they may serve a stale entry when they recover), even if some of them fail.
+// Their errors are joined and returned.
+//
+// Deletions of Broadcast levels are published after the entry was deleted from
+// all levels, so other processes do not promote the entry of a slower shared
+// level back to their local level.
 func (m *multiLevel) Del(ctx context.Context, k Key) error {
+	var (
+		errs []error
+		bs   []int
+	)
 	for i := range m.levels {
-		if err := m.levels[i].Del(ctx, k); err != nil {
-			return err
+		var err error
+		if b, ok := m.levels[i].(*Broadcast); ok {
+			if err = b.del(ctx, k); err == nil {
+				bs = append(bs, i)
+			}
+		} else {
+			err = m.levels[i].Del(ctx, k)
+		}
+		if err != nil {
+			if !m.fail(i, err) {
+				return errors.Join(err, m.publish(ctx, bs, Invalidation{Keys: []string{fmt.Sprint(k)}}))
+			}
+			errs = append(errs, err)
 		}
 	}
+	errs = append(errs, m.publish(ctx, bs, Invalidation{Keys: []string{fmt.Sprint(k)}}))
+	return errors.Join(errs...)
+}
+
+// publish publishes the invalidation on the buses of the given Broadcast levels.
+func (m *multiLevel) publish(ctx context.Context, bs []int, inv Invalidation) error {
+	var errs []error
+	for _, i := range bs {
+		if err := m.levels[i].(*Broadcast).bus.Publish(ctx, inv); err != nil {
+			if !m.fail(i, err) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Tag tags the entry in all levels that support tags. Wrapper levels
+// that report their wrapped level does not support tags are skipped.
+func (m *multiLevel) Tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	found := false
+	for i := range m.levels {
+		if t, ok := m.levels[i].(Tagger); ok {
+			switch err := t.Tag(ctx, k, tags, ttl); {
+			case errors.Is(err, errNoTagger):
+			case err != nil:
+				return err
+			default:
+				found = true
+			}
+		}
+	}
+	if !found {
+		return errNoTagger
+	}
 	return nil
 }
 
+// InvalidateTags invalidates the given tags in all levels that support tags.
+// Levels that do not support tags keep serving the invalidated entries until
+// they expire, and hence, they are reported in the returned error after the
+// tags were invalidated in all other levels.
+func (m *multiLevel) InvalidateTags(ctx context.Context, tags ...string) error {
+	var (
+		found       bool
+		unsupported []int
+		bs          []int
+	)
+	for i := range m.levels {
+		var err error
+		switch l := m.levels[i].(type) {
+		case *Broadcast:
+			// Invalidations are published after all levels
+			// were invalidated, similar to deletions.
+			if err = l.invalidateTags(ctx, tags...); err == nil {
+				bs = append(bs, i)
+			}
+		case Tagger:
+			err = l.InvalidateTags(ctx, tags...)
+		default:
+			err = errNoTagger
+		}
+		switch {
+		case errors.Is(err, errNoTagger):
+			unsupported = append(unsupported, i)
+		case err != nil:
+			return errors.Join(err, m.publish(ctx, bs, Invalidation{Tags: tags}))
+		default:
+			found = true
+		}
+	}
+	if err := m.publish(ctx, bs, Invalidation{Tags: tags}); err != nil {
+		return err
+	}
+	switch {
+	case !found:
+		return errNoTagger
+	case len(unsupported) > 0:
+		return fmt.Errorf("entcache: levels %v do not support tags, and may serve the invalidated entries until they expire", unsupported)
+	}
+	return nil
+}
+
+// Lock acquires the lock in the first level that supports locks.
+func (m *multiLevel) Lock(ctx context.Context, k Key, ttl time.Duration) (string, bool, error) {
+	for i := range m.levels {
+		if l, ok := m.levels[i].(Locker); ok {
+			if token, ok, err := l.Lock(ctx, k, ttl); !errors.Is(err, errNoLocker) {
+				return token, ok, err
+			}
+		}
+	}
+	return "", false, errNoLocker
+}
+
+// Unlock releases the lock in the first level that supports locks.
+func (m *multiLevel) Unlock(ctx context.Context, k Key, token string) error {
+	for i := range m.levels {
+		if l, ok := m.levels[i].(Locker); ok {
+			if err := l.Unlock(ctx, k, token); !errors.Is(err, errNoLocker) {
+				return err
+			}
+		}
+	}
+	return errNoLocker
+}
+
+// leaseSep separates the lease tokens of the levels.
+const leaseSep = "\x00"
+
+// Lease obtains a lease from all levels that support leases. The
+// returned token holds the tokens of all levels, in their order.
+func (m *multiLevel) Lease(ctx context.Context, k Key, ttl time.Duration) (string, error) {
+	tokens := make([]string, len(m.levels))
+	for i := range m.levels {
+		if l, ok := m.levels[i].(Leaser); ok && !m.skip(i) {
+			token, err := l.Lease(ctx, k, ttl)
+			// Levels without a lease reject the entry.
+			if err != nil && !m.fail(i, err) {
+				return "", err
+			}
+			tokens[i] = token
+		}
+	}
+	return strings.Join(tokens, leaseSep), nil
+}
+
+// AddLease adds the entry to all levels, using their lease tokens in levels
+// that support leases. Levels are populated from the last (i.e. slowest and
+// commonly shared) to the first, so that faster levels are not populated by
+// entries that were rejected by the slower ones.
+func (m *multiLevel) AddLease(ctx context.Context, k Key, token string, e *Entry, ttl time.Duration) error {
+	tokens := strings.Split(token, leaseSep)
+	if len(tokens) != len(m.levels) {
+		return ErrLeaseRevoked
+	}
+	var errs []error
+	for i := len(m.levels) - 1; i >= 0; i-- {
+		if m.skip(i) {
+			continue
+		}
+		var err error
+		if l, ok := m.levels[i].(Leaser); ok {
+			err = l.AddLease(ctx, k, tokens[i], e, ttl)
+		} else {
+			err = m.levels[i].Add(ctx, k, e, ttl)
+		}
+		if err != nil {
+			if errors.Is(err, ErrLeaseRevoked) || !m.fail(i, err) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Size returns the size of the first level, if it supports it. The sizes of
+// the levels are not summed, as they are measured in different resources
+// (e.g., memory and disk). They are reported by stats.
+func (m *multiLevel) Size() int64 {
+	if len(m.levels) == 0 {
+		return 0
+	}
+	if s, ok := m.levels[0].(Sizer); ok {
+		return s.Size()
+	}
+	return 0
+}
+
 // contextLevel provides a context/request level cache implementation.
 type contextLevel struct{}
 
@@ -140,3 +561,29 @@ func (*contextLevel) Del(ctx context.Context, k Key) error {
 	}
 	return c.Del(ctx, k)
 }
+
+// Tag tags the entry in the context cache.
+func (*contextLevel) Tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	c, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	t, ok := c.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.Tag(ctx, k, tags, ttl)
+}
+
+// InvalidateTags invalidates the given tags in the context cache.
+func (*contextLevel) InvalidateTags(ctx context.Context, tags ...string) error {
+	c, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	t, ok := c.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.InvalidateTags(ctx, tags...)
+}
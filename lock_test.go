@@ -0,0 +1,176 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/rueidis"
+	ruemock "github.com/redis/rueidis/mock"
+	"go.uber.org/mock/gomock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+// lockLevel is an LRU level that supports locks, and simulates
+// a cache level that is shared with other processes.
+type lockLevel struct {
+	*entcache.LRU
+	mu     sync.Mutex
+	n      int
+	locks  map[string]string
+	locked int
+}
+
+func newLockLevel() *lockLevel {
+	return &lockLevel{LRU: entcache.NewLRU(0), locks: make(map[string]string)}
+}
+
+func (l *lockLevel) Lock(_ context.Context, k entcache.Key, _ time.Duration) (string, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := strconv.Itoa(k.(int))
+	if _, ok := l.locks[key]; ok {
+		return "", false, nil
+	}
+	l.n++
+	l.locked++
+	l.locks[key] = strconv.Itoa(l.n)
+	return l.locks[key], true, nil
+}
+
+func (l *lockLevel) Unlock(_ context.Context, k entcache.Key, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if key := strconv.Itoa(k.(int)); l.locks[key] == token {
+		delete(l.locks, key)
+	}
+	return nil
+}
+
+func TestDriver_DistributedLock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := entcache.Cache(context.Background())
+	newDriver := func(level entcache.AddGetDeleter) *entcache.Driver {
+		return entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.TTL(time.Minute),
+			entcache.Levels(level),
+			entcache.WithDistributedLock(50*time.Millisecond),
+			entcache.Hash(func(string, []any) (entcache.Key, error) {
+				return 1, nil
+			}),
+		)
+	}
+
+	t.Run("Acquire", func(t *testing.T) {
+		level := newLockLevel()
+		drv := newDriver(level)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if level.locked != 1 || len(level.locks) != 0 {
+			t.Fatalf("expected lock to be acquired and released: %v", level.locks)
+		}
+		if _, err := level.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Wait", func(t *testing.T) {
+		level := newLockLevel()
+		drv := newDriver(level)
+		// Another process holds the lock, and stores the entry.
+		if _, ok, _ := level.Lock(ctx, 1, time.Minute); !ok {
+			t.Fatal("expected lock to be acquired")
+		}
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_ = level.Add(ctx, 1, &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}, 0)
+		}()
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		if s := drv.Stats(); s.Coalesced != 1 || s.Hits != 0 {
+			t.Fatalf("unexpected stats: %v", s)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		level := newLockLevel()
+		drv := newDriver(level)
+		// Another process holds the lock, and dies.
+		if _, ok, _ := level.Lock(ctx, 1, time.Minute); !ok {
+			t.Fatal("expected lock to be acquired")
+		}
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		start := time.Now()
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if time.Since(start) < 50*time.Millisecond {
+			t.Fatal("expected query to wait for the lock expiry")
+		}
+		if s := drv.Stats(); s.Coalesced != 0 {
+			t.Fatalf("unexpected stats: %v", s)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		drv := newDriver(entcache.NewLRU(0))
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if s := drv.Stats(); s.Errors != 0 {
+			t.Fatalf("unexpected stats: %v", s)
+		}
+	})
+}
+
+func TestRedis_Lock(t *testing.T) {
+	var (
+		ctx = context.Background()
+		rdb = ruemock.NewClient(gomock.NewController(t))
+		r   = entcache.NewRedis(rdb)
+	)
+	var token string
+	rdb.EXPECT().Do(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, cmd rueidis.Completed) rueidis.RedisResult {
+		args := cmd.Commands()
+		if len(args) != 6 || args[0] != "SET" || args[1] != "entcache:lock:1" || args[3] != "NX" || args[4] != "PX" || args[5] != "1000" {
+			t.Fatalf("unexpected command: %v", args)
+		}
+		token = args[2]
+		return ruemock.Result(ruemock.RedisString("OK"))
+	})
+	got, ok, err := r.Lock(ctx, 1, time.Second)
+	if err != nil || !ok || got != token {
+		t.Fatalf("unexpected lock: %q %v %v", got, ok, err)
+	}
+
+	rdb.EXPECT().Do(ctx, gomock.Any()).Return(ruemock.Result(ruemock.RedisNil()))
+	if _, ok, err := r.Lock(ctx, 1, time.Second); err != nil || ok {
+		t.Fatalf("expected lock to be held: %v %v", ok, err)
+	}
+
+	rdb.EXPECT().Do(ctx, ruemock.MatchFn(func(cmd []string) bool {
+		return cmd[0] == "EVAL" && cmd[2] == "1" && cmd[3] == "entcache:lock:1" && cmd[4] == token
+	})).Return(ruemock.Result(ruemock.RedisInt64(1)))
+	if err := r.Unlock(ctx, 1, token); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,213 @@
+package entcache
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// Level wraps a cache level with level-specific policies, such as its TTL
+	// and the maximum size of its entries. It implements the AddGetDeleter
+	// interface, and hence, it can be passed to Levels. For example, an LRU
+	// that holds entries for 5 seconds and a Redis that holds them for 10 minutes:
+	//
+	//	entcache.NewDriver(
+	//		drv,
+	//		entcache.TTL(time.Minute),
+	//		entcache.Levels(
+	//			entcache.NewLevel(entcache.NewLRU(256), entcache.LevelTTL(5*time.Second)),
+	//			entcache.NewLevel(entcache.NewRedis(rdb), entcache.LevelTTL(10*time.Minute)),
+	//		),
+	//	)
+	Level struct {
+		level        AddGetDeleter
+		ttl          time.Duration
+		multiplier   float64
+		maxEntrySize int64
+		readOnly     bool
+		writeOnly    bool
+	}
+
+	// LevelOption allows configuring the level
+	// policies using functional options.
+	LevelOption func(*Level)
+)
+
+// NewLevel returns a new Level that wraps the given cache level with the given policies.
+func NewLevel(level AddGetDeleter, opts ...LevelOption) *Level {
+	l := &Level{level: level}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// LevelTTL overrides the TTL of the entries that are added to the level.
+func LevelTTL(ttl time.Duration) LevelOption {
+	return func(l *Level) {
+		l.ttl = ttl
+	}
+}
+
+// LevelTTLMultiplier scales the TTL of the entries that are added to the level
+// by the given factor. Entries without a TTL are not affected, and LevelTTL
+// takes precedence if both are configured.
+func LevelTTLMultiplier(f float64) LevelOption {
+	return func(l *Level) {
+		l.multiplier = f
+	}
+}
+
+// LevelMaxEntrySize configures the level to skip entries whose approximate
+// size in bytes exceeds the given size. The previous entry of the key is
+// deleted from the level, as it is stale.
+func LevelMaxEntrySize(n int64) LevelOption {
+	return func(l *Level) {
+		l.maxEntrySize = n
+	}
+}
+
+// LevelReadOnly configures the level to be read-only, i.e. entries are read
+// from it, but not added to it. Entries are still deleted from the level, so
+// invalidations are applied to it.
+func LevelReadOnly() LevelOption {
+	return func(l *Level) {
+		l.readOnly = true
+	}
+}
+
+// LevelWriteOnly configures the level to be write-only, i.e. entries are added
+// to it, but never read from it (e.g., for populating a new level before it
+// serves reads).
+func LevelWriteOnly() LevelOption {
+	return func(l *Level) {
+		l.writeOnly = true
+	}
+}
+
+// Add adds the entry to the level, if it matches its policies.
+func (l *Level) Add(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	if l.readOnly {
+		return nil
+	}
+	if l.maxEntrySize > 0 && entrySize(e) > l.maxEntrySize {
+		return l.level.Del(ctx, k)
+	}
+	return l.level.Add(ctx, k, e, l.levelTTL(ttl))
+}
+
+// promote adds an entry that was promoted from a slower level, with the given
+// remaining TTL. The TTL of the level is capped by the remaining TTL, so the
+// promoted entry does not outlive the entry of the slower level.
+func (l *Level) promote(ctx context.Context, k Key, e *Entry, ttl time.Duration) error {
+	if l.readOnly || l.maxEntrySize > 0 && entrySize(e) > l.maxEntrySize {
+		return nil
+	}
+	if lt := l.levelTTL(ttl); ttl == 0 || lt > 0 && lt < ttl {
+		ttl = lt
+	}
+	return l.level.Add(ctx, k, e, ttl)
+}
+
+// Get gets an entry from the level, unless it is write-only.
+func (l *Level) Get(ctx context.Context, k Key) (*Entry, error) {
+	if l.writeOnly {
+		return nil, ErrNotFound
+	}
+	return l.level.Get(ctx, k)
+}
+
+// Del deletes an entry from the level.
+func (l *Level) Del(ctx context.Context, k Key) error {
+	return l.level.Del(ctx, k)
+}
+
+// GetTTL gets an entry from the level and its remaining TTL, if the level supports it.
+func (l *Level) GetTTL(ctx context.Context, k Key) (*Entry, time.Duration, error) {
+	if l.writeOnly {
+		return nil, 0, ErrNotFound
+	}
+	if t, ok := l.level.(TTLGetter); ok {
+		return t.GetTTL(ctx, k)
+	}
+	e, err := l.level.Get(ctx, k)
+	return e, -1, err
+}
+
+// Lease returns a lease token for the given key, if the level supports leases.
+func (l *Level) Lease(ctx context.Context, k Key, ttl time.Duration) (string, error) {
+	if ls, ok := l.level.(Leaser); ok && !l.readOnly {
+		return ls.Lease(ctx, k, ttl)
+	}
+	return "", nil
+}
+
+// AddLease adds the entry to the level if it matches its policies, using the
+// lease token if the level supports leases.
+func (l *Level) AddLease(ctx context.Context, k Key, token string, e *Entry, ttl time.Duration) error {
+	ls, ok := l.level.(Leaser)
+	switch {
+	case !ok || l.readOnly:
+		return l.Add(ctx, k, e, ttl)
+	case l.maxEntrySize > 0 && entrySize(e) > l.maxEntrySize:
+		return l.level.Del(ctx, k)
+	default:
+		return ls.AddLease(ctx, k, token, e, l.levelTTL(ttl))
+	}
+}
+
+// Tag tags the entry in the level, if it supports tags.
+func (l *Level) Tag(ctx context.Context, k Key, tags []string, ttl time.Duration) error {
+	t, ok := l.level.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.Tag(ctx, k, tags, l.levelTTL(ttl))
+}
+
+// InvalidateTags invalidates the given tags in the level, if it supports tags.
+func (l *Level) InvalidateTags(ctx context.Context, tags ...string) error {
+	t, ok := l.level.(Tagger)
+	if !ok {
+		return errNoTagger
+	}
+	return t.InvalidateTags(ctx, tags...)
+}
+
+// Lock acquires the lock in the level, if it supports locks.
+func (l *Level) Lock(ctx context.Context, k Key, ttl time.Duration) (string, bool, error) {
+	lk, ok := l.level.(Locker)
+	if !ok {
+		return "", false, errNoLocker
+	}
+	return lk.Lock(ctx, k, ttl)
+}
+
+// Unlock releases the lock in the level, if it supports locks.
+func (l *Level) Unlock(ctx context.Context, k Key, token string) error {
+	lk, ok := l.level.(Locker)
+	if !ok {
+		return errNoLocker
+	}
+	return lk.Unlock(ctx, k, token)
+}
+
+// Size returns the size of the level, if it supports it.
+func (l *Level) Size() int64 {
+	if s, ok := l.level.(Sizer); ok {
+		return s.Size()
+	}
+	return 0
+}
+
+// levelTTL returns the TTL of the entry in the level.
+func (l *Level) levelTTL(ttl time.Duration) time.Duration {
+	switch {
+	case l.ttl != 0:
+		return l.ttl
+	case l.multiplier > 0 && ttl > 0:
+		return time.Duration(float64(ttl) * l.multiplier)
+	default:
+		return ttl
+	}
+}
@@ -0,0 +1,137 @@
+package entcache_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestLevel(t *testing.T) {
+	ctx := context.Background()
+	e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+
+	t.Run("TTL", func(t *testing.T) {
+		l1, l2, l3 := entcache.NewLRU(0), entcache.NewLRU(0), entcache.NewLRU(0)
+		drv := entcache.NewDriver(nil, entcache.Levels(
+			entcache.NewLevel(l1, entcache.LevelTTL(5*time.Second)),
+			entcache.NewLevel(l2, entcache.LevelTTLMultiplier(10)),
+			l3,
+		))
+		if err := drv.Cache.Add(ctx, 1, e, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		for i, c := range []struct {
+			l        *entcache.LRU
+			min, max time.Duration
+		}{
+			{l1, 4 * time.Second, 5 * time.Second},
+			{l2, 9 * time.Minute, 10 * time.Minute},
+			{l3, 59 * time.Second, time.Minute},
+		} {
+			if _, ttl, err := c.l.GetTTL(ctx, 1); err != nil || ttl < c.min || ttl > c.max {
+				t.Fatalf("level %d: unexpected TTL: %v %v", i, ttl, err)
+			}
+		}
+		// Promoted entries do not outlive the entry of the slower level.
+		if err := l1.Del(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := l3.Add(ctx, 2, e, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := drv.Cache.Get(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, ttl, err := l1.GetTTL(ctx, 2); err != nil || ttl <= 0 || ttl > time.Second {
+			t.Fatalf("unexpected promoted TTL: %v %v", ttl, err)
+		}
+	})
+
+	t.Run("MaxEntrySize", func(t *testing.T) {
+		inner := entcache.NewLRU(0)
+		l := entcache.NewLevel(inner, entcache.LevelMaxEntrySize(512))
+		if err := l.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		large := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{strings.Repeat("a", 1024)}}}
+		if err := l.Add(ctx, 1, large, 0); err != nil {
+			t.Fatal(err)
+		}
+		// The previous entry is stale, and deleted.
+		if _, err := inner.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("ReadWrite", func(t *testing.T) {
+		ro, wo := entcache.NewLRU(0), entcache.NewLRU(0)
+		drv := entcache.NewDriver(nil, entcache.Levels(
+			entcache.NewLevel(wo, entcache.LevelWriteOnly()),
+			entcache.NewLevel(ro, entcache.LevelReadOnly()),
+		))
+		if err := drv.Cache.Add(ctx, 1, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ro.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected read-only level to be skipped, got: %v", err)
+		}
+		if _, err := wo.Get(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := drv.Cache.Get(ctx, 1); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected write-only level to be skipped, got: %v", err)
+		}
+		if err := ro.Add(ctx, 2, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := drv.Cache.Get(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+		// Invalidations are applied to all levels.
+		if err := drv.Cache.Del(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ro.Get(ctx, 2); !errors.Is(err, entcache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+	})
+}
+
+func TestDriver_LevelTags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lru := entcache.NewLRU(0)
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(
+			entcache.NewLevel(lru, entcache.LevelTTL(time.Minute)),
+			entcache.NewLevel(unsupported{}),
+		),
+	)
+	ctx := entcache.Cache(context.Background(), entcache.WithTags("users"))
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	// The tags are invalidated in the levels that support them, and
+	// the levels that do not support them are reported.
+	if err := drv.InvalidateTags(ctx, "users"); err == nil || !strings.Contains(err.Error(), "levels [1] do not support tags") {
+		t.Fatalf("expected error for level without tags support, got: %v", err)
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+package entcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+)
+
+// errClosed is returned when jobs are registered on a closed driver.
+var errClosed = errors.New("entcache: driver is closed")
+
+// refresher runs the refresh-ahead jobs of the driver.
+type refresher struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// RefreshAhead registers a query that is executed in the background every
+// interval, and overwrites its cache entry with the result (see Refresh).
+// The query is executed once immediately, and hence, its entry is never cold
+// if the interval is shorter than its TTL. The query and its arguments must
+// be identical to the ones used by the application for sharing the same cache
+// key (e.g., int64 and int arguments are hashed differently), or a custom key
+// should be set with WithKey.
+//
+//	drv.RefreshAhead(
+//		30*time.Second,
+//		"SELECT COUNT(*) FROM `users` WHERE `active` = ?", []any{true},
+//		entcache.WithTTL(time.Minute),
+//	)
+//
+// Jobs run until the driver is closed.
+func (d *Driver) RefreshAhead(interval time.Duration, query string, args []any, opts ...QueryOption) error {
+	return d.RefreshAheadFunc(interval, func(ctx context.Context) error {
+		return d.Query(ctx, query, args, &sql.Rows{})
+	}, opts...)
+}
+
+// RefreshAheadFunc registers a function that is called in the background every
+// interval (and once immediately), with a context that is configured with the
+// Refresh option and the given options. Hence, it allows refreshing queries that
+// are built by the ent client (including eager loading), as they overwrite their
+// cache entries. For example:
+//
+//	drv.RefreshAheadFunc(30*time.Second, func(ctx context.Context) error {
+//		_, err := client.User.Query().Where(user.Active(true)).WithPets().All(ctx)
+//		return err
+//	})
+//
+// Jobs run until the driver is closed.
+func (d *Driver) RefreshAheadFunc(interval time.Duration, fn func(context.Context) error, opts ...QueryOption) error {
+	if interval <= 0 {
+		return fmt.Errorf("entcache: invalid refresh interval: %v", interval)
+	}
+	r := &d.refresher
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return errClosed
+	}
+	if r.ctx == nil {
+		r.ctx, r.cancel = context.WithCancel(context.Background())
+	}
+	ctx := Cache(r.ctx, append([]QueryOption{Refresh()}, opts...)...)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := fn(ctx); err != nil && ctx.Err() == nil && d.Log != nil {
+				atomic.AddUint64(&d.stats.Errors, 1)
+				d.Log(fmt.Sprintf("entcache: failed refreshing query: %v", err))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the refresh-ahead jobs of the driver, waits for the running
+// ones to return, stores the writes that were queued by the async writer
+// (see AsyncWrites), and closes the underlying driver.
+func (d *Driver) Close() error {
+	r := &d.refresher
+	r.mu.Lock()
+	r.closed = true
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	if d.writer != nil {
+		d.writer.close()
+	}
+	return d.Driver.Close()
+}
@@ -0,0 +1,99 @@
+package entcache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestDriver_Refresh(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	ctx := entcache.Cache(context.Background())
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("nati"))
+	expectQuery(entcache.Cache(ctx, entcache.Refresh()), t, drv, "SELECT name FROM users", []any{"nati"})
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"nati"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Gets != 2 || s.Hits != 1 {
+		t.Fatalf("unexpected stats: %v", s)
+	}
+}
+
+func TestDriver_RefreshAhead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The jobs query concurrently, and sqlmock expects Close
+	// to be called once, that is, on a single connection.
+	db.SetMaxOpenConns(1)
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.TTL(time.Minute),
+		entcache.Hash(func(query string, _ []any) (entcache.Key, error) {
+			return query, nil
+		}),
+	)
+	ctx := entcache.Cache(context.Background())
+	// waitFor waits until the cached entry of the query holds the given value.
+	waitFor := func(t *testing.T, query, v string) {
+		t.Helper()
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+			if e, err := drv.Cache.Get(ctx, query); err == nil && e.Values[0][0] == v {
+				return
+			}
+		}
+		t.Fatalf("entry was not refreshed with value %q", v)
+	}
+	mock.MatchExpectationsInOrder(false)
+	for _, v := range []string{"a8m", "nati"} {
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(v))
+		mock.ExpectQuery("SELECT name FROM pets").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(v + "'s pet"))
+	}
+	if err := drv.RefreshAhead(50*time.Millisecond, "SELECT name FROM users", nil); err != nil {
+		t.Fatal(err)
+	}
+	err = drv.RefreshAheadFunc(50*time.Millisecond, func(ctx context.Context) error {
+		rows := &sql.Rows{}
+		if err := drv.Query(ctx, "SELECT name FROM pets", []any{}, rows); err != nil {
+			return err
+		}
+		return rows.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "SELECT name FROM users", "nati")
+	waitFor(t, "SELECT name FROM pets", "nati's pet")
+
+	mock.ExpectClose()
+	if err := drv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.RefreshAhead(time.Second, "SELECT name FROM users", nil); err == nil {
+		t.Fatal("expected error for closed driver")
+	}
+	if err := drv.RefreshAhead(0, "SELECT name FROM users", nil); err == nil {
+		t.Fatal("expected error for invalid interval")
+	}
+}
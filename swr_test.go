@@ -0,0 +1,117 @@
+package entcache_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestDriver_StaleWhileRevalidate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu   sync.Mutex
+		logs []string
+		ctx  = entcache.Cache(context.Background())
+	)
+	newDriver := func(ttl, grace time.Duration) *entcache.Driver {
+		return entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.TTL(ttl),
+			entcache.StaleWhileRevalidate(grace),
+			entcache.Hash(func(string, []any) (entcache.Key, error) {
+				return 1, nil
+			}),
+			func(o *entcache.Options) {
+				o.Log = func(v ...any) {
+					mu.Lock()
+					logs = append(logs, fmt.Sprint(v...))
+					mu.Unlock()
+				}
+			},
+		)
+	}
+	// waitFor waits until the cached entry holds the given value.
+	waitFor := func(t *testing.T, drv *entcache.Driver, v string) {
+		t.Helper()
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+			if e, err := drv.Cache.Get(ctx, 1); err == nil && e.Values[0][0] == v {
+				return
+			}
+		}
+		t.Fatalf("entry was not revalidated with value %q", v)
+	}
+
+	t.Run("Revalidate", func(t *testing.T) {
+		drv := newDriver(50*time.Millisecond, time.Hour)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		time.Sleep(60 * time.Millisecond)
+
+		// Stale entries are served while a single refresh is executed.
+		mock.ExpectQuery("SELECT name FROM users").
+			WillDelayFor(50 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("nati"))
+		for range 3 {
+			expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		}
+		waitFor(t, drv, "nati")
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"nati"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if s := drv.Stats(); s.Stale != 3 || s.Hits != 4 {
+			t.Errorf("unexpected stats: %v", s)
+		}
+	})
+
+	t.Run("HardExpiry", func(t *testing.T) {
+		drv := newDriver(10*time.Millisecond, 20*time.Millisecond)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		time.Sleep(40 * time.Millisecond)
+
+		// Entries past the grace period are not served.
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("nati"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"nati"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if s := drv.Stats(); s.Stale != 0 {
+			t.Errorf("unexpected stats: %v", s)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		drv := newDriver(time.Hour, 0)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		e, err := drv.Cache.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !e.StaleAt.IsZero() {
+			t.Fatalf("unexpected soft expiry: %v", e.StaleAt)
+		}
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(logs) > 0 {
+		t.Fatalf("unexpected errors: %v", logs)
+	}
+}
@@ -0,0 +1,354 @@
+package entcache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tableIndex maps table names to the cache keys of the
+// entries that were built from them.
+//
+// Note that the index is kept in process memory. Hence, entries
+// that were stored in a shared cache level by other processes (or
+// before a restart) are not tracked and expire only by their TTL.
+type tableIndex struct {
+	mu     sync.Mutex
+	keys   map[string]map[Key]struct{}
+	tables map[Key]indexEntry
+	// order holds the indexed keys from the oldest to the newest,
+	// and max limits their number (zero means no limit).
+	order *list.List
+	max   int
+	// size of the index after the last sweep of expired keys.
+	swept int
+}
+
+// indexEntry holds the tables of an indexed key, its expiry,
+// and its element in the order list.
+type indexEntry struct {
+	tables  []string
+	expires time.Time
+	elem    *list.Element
+}
+
+// minIndexSweep is the minimum size of the index for sweeping its expired keys.
+const minIndexSweep = 1024
+
+// add indexes the given key under all tables, replacing its previous tables.
+// Keys with a positive TTL are removed from the index after they expire. If
+// the index is full, the oldest keys are dropped from it to make room for the
+// new one, and returned. The caller must evict them from the cache, as they
+// are not invalidated anymore when their tables are modified.
+func (t *tableIndex) add(k Key, tables []string, ttl time.Duration) []Key {
+	if len(tables) == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.keys == nil {
+		t.keys = make(map[string]map[Key]struct{})
+		t.tables = make(map[Key]indexEntry)
+		t.order = list.New()
+	}
+	t.remove(k)
+	for _, name := range tables {
+		if t.keys[name] == nil {
+			t.keys[name] = make(map[Key]struct{})
+		}
+		t.keys[name][k] = struct{}{}
+	}
+	e := indexEntry{tables: tables, elem: t.order.PushBack(k)}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	t.tables[k] = e
+	// Expired keys are swept when the index doubles its size,
+	// and hence, the cost of sweeping is amortized over adds.
+	if n := len(t.tables); n >= minIndexSweep && n >= 2*t.swept {
+		now := time.Now()
+		for k, e := range t.tables {
+			if !e.expires.IsZero() && now.After(e.expires) {
+				t.remove(k)
+			}
+		}
+		t.swept = len(t.tables)
+	}
+	var dropped []Key
+	for t.max > 0 && len(t.tables) > t.max {
+		k := t.order.Front().Value
+		t.remove(k)
+		dropped = append(dropped, k)
+	}
+	return dropped
+}
+
+// del removes the given key from the index.
+func (t *tableIndex) del(k Key) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(k)
+}
+
+// take removes and returns all keys that depend on at least one of the given tables.
+func (t *tableIndex) take(tables []string) []Key {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var keys []Key
+	for _, name := range tables {
+		for k := range t.keys[name] {
+			keys = append(keys, k)
+			t.remove(k)
+		}
+	}
+	return keys
+}
+
+// remove removes the given key from the index. The lock must be held.
+func (t *tableIndex) remove(k Key) {
+	e, ok := t.tables[k]
+	if !ok {
+		return
+	}
+	for _, name := range e.tables {
+		delete(t.keys[name], k)
+		if len(t.keys[name]) == 0 {
+			delete(t.keys, name)
+		}
+	}
+	t.order.Remove(e.elem)
+	delete(t.tables, k)
+}
+
+// token kinds returned by the SQL tokenizer.
+const (
+	tokWord   = iota // keyword or bare identifier.
+	tokQuoted        // quoted identifier.
+	tokPunct         // one of ( ) , . ;
+	tokOther         // literals and operators.
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+// is reports if the token is a keyword or punctuation equal to s.
+func (t token) is(s string) bool {
+	return (t.kind == tokWord || t.kind == tokPunct) && strings.EqualFold(t.text, s)
+}
+
+// tokenize splits the given SQL statement into tokens. Comments and
+// whitespaces are dropped, and the content of string literals is ignored.
+func tokenize(query string) []token {
+	var toks []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j != -1 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j != -1 {
+				i += j + 4
+			} else {
+				i = len(query)
+			}
+		case c == '\'':
+			i = skipQuoted(query, i, '\'')
+			toks = append(toks, token{kind: tokOther})
+		case c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := skipQuoted(query, i, end)
+			text := strings.TrimSuffix(query[i+1:j], string(end))
+			toks = append(toks, token{kind: tokQuoted, text: strings.ReplaceAll(text, string([]byte{end, end}), string(end))})
+			i = j
+		case c == '(' || c == ')' || c == ',' || c == '.' || c == ';':
+			toks = append(toks, token{kind: tokPunct, text: string(c)})
+			i++
+		case isWordChar(c):
+			j := i + 1
+			for j < len(query) && isWordChar(query[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokWord, text: query[i:j]})
+			i = j
+		default:
+			toks = append(toks, token{kind: tokOther, text: string(c)})
+			i++
+		}
+	}
+	return toks
+}
+
+// skipQuoted returns the index following the quoted
+// section that starts at i and terminated by end.
+func skipQuoted(query string, i int, end byte) int {
+	for j := i + 1; j < len(query); j++ {
+		switch {
+		case query[j] == '\\' && end == '\'':
+			j++
+		case query[j] == end && j+1 < len(query) && query[j+1] == end:
+			j++
+		case query[j] == end:
+			return j + 1
+		}
+	}
+	return len(query)
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// reserved holds the keywords that may follow a table reference,
+// and therefore cannot be treated as its alias.
+var reserved = map[string]bool{
+	"AS": true, "ON": true, "USING": true, "WHERE": true, "JOIN": true, "INNER": true,
+	"LEFT": true, "RIGHT": true, "FULL": true, "CROSS": true, "OUTER": true, "NATURAL": true,
+	"STRAIGHT_JOIN": true, "GROUP": true, "ORDER": true, "HAVING": true, "LIMIT": true,
+	"OFFSET": true, "UNION": true, "EXCEPT": true, "INTERSECT": true, "FOR": true, "WINDOW": true,
+	"SET": true, "VALUES": true, "RETURNING": true, "LATERAL": true, "ONLY": true, "SELECT": true,
+	"DEFAULT": true, "IF": true, "EXISTS": true, "NOT": true, "CASCADE": true, "RESTRICT": true,
+}
+
+// tableName reads a (possibly qualified) table name starting at toks[i]. It returns
+// the normalized name, that is, the lowercase unqualified name, and the index of the
+// token following it.
+func tableName(toks []token, i int) (string, int, bool) {
+	var name string
+	for i < len(toks) {
+		t := toks[i]
+		if t.kind != tokQuoted && (t.kind != tokWord || reserved[strings.ToUpper(t.text)]) {
+			break
+		}
+		name = t.text
+		if i+2 < len(toks) && toks[i+1].is(".") {
+			i += 2
+			continue
+		}
+		i++
+		break
+	}
+	return strings.ToLower(name), i, name != ""
+}
+
+// tableList reads a comma-separated list of table references (with optional aliases)
+// starting at toks[i], and returns their names. The list ends on subqueries.
+func tableList(toks []token, i int) []string {
+	var names []string
+	for i < len(toks) {
+		for i < len(toks) && (toks[i].is("ONLY") || toks[i].is("LATERAL") || toks[i].is("TABLE")) {
+			i++
+		}
+		name, j, ok := tableName(toks, i)
+		if !ok {
+			break
+		}
+		names = append(names, name)
+		if i = j; i < len(toks) && toks[i].is("AS") {
+			i++
+		}
+		if _, j, ok := tableName(toks, i); ok {
+			i = j
+		}
+		if i >= len(toks) || !toks[i].is(",") {
+			break
+		}
+		i++
+	}
+	return names
+}
+
+// readTables returns the tables that are read by the given query. That includes the
+// tables that are referenced by FROM and JOIN clauses of the query and its subqueries.
+func readTables(query string) []string {
+	var (
+		names []string
+		toks  = tokenize(query)
+	)
+	for i, t := range toks {
+		if t.is("FROM") || t.is("JOIN") {
+			names = appendUnique(names, tableList(toks, i+1)...)
+		}
+	}
+	return names
+}
+
+// writeTables returns the tables that are modified by the given statement,
+// or nil if the statement is not recognized as a data or schema modification.
+func writeTables(query string) []string {
+	toks := tokenize(query)
+	depth := 0
+	for i, t := range toks {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth > 0 || t.kind != tokWord:
+		case t.is("INSERT"), t.is("REPLACE"), t.is("MERGE"):
+			return tableList(toks, indexOf(toks, i+1, "INTO")+1)
+		case t.is("UPDATE"):
+			i++
+			for i < len(toks) && (toks[i].is("LOW_PRIORITY") || toks[i].is("IGNORE")) {
+				i++
+			}
+			return tableList(toks, i)
+		case t.is("DELETE"):
+			return tableList(toks, indexOf(toks, i+1, "FROM")+1)
+		case t.is("TRUNCATE"):
+			return tableList(toks, i+1)
+		case t.is("ALTER"), t.is("DROP"):
+			if i+1 < len(toks) && toks[i+1].is("TABLE") {
+				i += 2
+				for i < len(toks) && (toks[i].is("IF") || toks[i].is("EXISTS")) {
+					i++
+				}
+				return tableList(toks, i)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// indexOf returns the index of the first top-level keyword s in toks[i:], or len(toks).
+func indexOf(toks []token, i int, s string) int {
+	for depth := 0; i < len(toks); i++ {
+		switch {
+		case toks[i].is("("):
+			depth++
+		case toks[i].is(")"):
+			depth--
+		case depth == 0 && toks[i].is(s):
+			return i
+		}
+	}
+	return len(toks)
+}
+
+func appendUnique(s []string, vs ...string) []string {
+	for _, v := range vs {
+		exists := false
+		for i := range s {
+			if s[i] == v {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			s = append(s, v)
+		}
+	}
+	return s
+}
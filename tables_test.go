@@ -0,0 +1,113 @@
+package entcache_test
+
+import (
+	"context"
+	"testing"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestDriver_TableInvalidation(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := sql.OpenDB(dialect.Postgres, db)
+	const (
+		users = `SELECT "users"."name" FROM "users"`
+		pets  = `SELECT "t1"."name" FROM "pets" AS "t1" JOIN (SELECT "users"."id" FROM "public"."users" WHERE "users"."age" > $1) AS "t2" ON "t1"."owner_id" = "t2"."id"`
+		cars  = "SELECT `c`.`model` FROM `cars` AS `c` WHERE `c`.`id` IN (SELECT `car_id` FROM `owners`)"
+	)
+
+	t.Run("Exec", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.WithTableInvalidation(true))
+		ctx := entcache.Cache(context.Background())
+		for _, q := range []string{users, pets, cars} {
+			mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+			expectQuery(ctx, t, drv, q, []any{"a8m"})
+			expectQuery(ctx, t, drv, q, []any{"a8m"})
+		}
+
+		// Queries that read from "users" (directly or in a subquery) should be evicted.
+		mock.ExpectExec(`UPDATE "users" SET "name" = $1`).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := drv.Exec(ctx, `UPDATE "users" SET "name" = $1`, []any{"a8m"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		mock.ExpectQuery(pets).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, pets, []any{"a8m"})
+		expectQuery(ctx, t, drv, cars, []any{"a8m"})
+
+		mock.ExpectExec("DELETE FROM `owners` WHERE `id` = ?").WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := drv.Exec(ctx, "DELETE FROM `owners` WHERE `id` = ?", []any{1}, nil); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(cars).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, cars, []any{"a8m"})
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		expectQuery(ctx, t, drv, pets, []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Returning", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.WithTableInvalidation(true))
+		ctx := entcache.Cache(context.Background())
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+
+		const insert = `INSERT INTO "users" ("name") VALUES ($1) RETURNING "id"`
+		mock.ExpectQuery(insert).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectQuery(ctx, t, drv, insert, []any{int64(1)})
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("IndexSize", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.WithTableInvalidation(true), entcache.TableIndexSize(2))
+		ctx := entcache.Cache(context.Background())
+		for _, q := range []string{users, pets, cars} {
+			mock.ExpectQuery(q).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+			expectQuery(ctx, t, drv, q, []any{"a8m"})
+		}
+		// The oldest key was dropped from the index, and its entry was evicted.
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		expectQuery(ctx, t, drv, cars, []any{"a8m"})
+		// The evicted key was indexed again, and is invalidated.
+		mock.ExpectExec(`UPDATE "users" SET "name" = $1`).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := drv.Exec(ctx, `UPDATE "users" SET "name" = $1`, []any{"a8m"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		drv := entcache.NewDriver(drv)
+		ctx := entcache.Cache(context.Background())
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		mock.ExpectExec(`DELETE FROM "users"`).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := drv.Exec(ctx, `DELETE FROM "users"`, []any{}, nil); err != nil {
+			t.Fatal(err)
+		}
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package entcache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/rueidis"
+	ruemock "github.com/redis/rueidis/mock"
+	"go.uber.org/mock/gomock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestDriver_Tags(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := sql.OpenDB(dialect.MySQL, db)
+
+	t.Run("LRU", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.Levels(entcache.NewLRU(0), entcache.NewLRU(0)))
+		ctx := context.Background()
+		user := entcache.Cache(ctx, entcache.WithTags("user:1"))
+		tenant := entcache.Cache(ctx, entcache.WithTags("user:1", "tenant:acme"))
+		plain := entcache.Cache(ctx)
+		mock.ExpectQuery("SELECT name FROM users WHERE id = 1").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		mock.ExpectQuery("SELECT id FROM users WHERE tenant = 'acme'").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		for range 2 {
+			expectQuery(user, t, drv, "SELECT name FROM users WHERE id = 1", []any{"a8m"})
+			expectQuery(tenant, t, drv, "SELECT id FROM users WHERE tenant = 'acme'", []any{int64(1)})
+			expectQuery(plain, t, drv, "SELECT name FROM users", []any{"a8m"})
+		}
+
+		if err := drv.InvalidateTags(ctx, "user:1"); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery("SELECT name FROM users WHERE id = 1").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		mock.ExpectQuery("SELECT id FROM users WHERE tenant = 'acme'").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectQuery(user, t, drv, "SELECT name FROM users WHERE id = 1", []any{"a8m"})
+		expectQuery(tenant, t, drv, "SELECT id FROM users WHERE tenant = 'acme'", []any{int64(1)})
+		expectQuery(plain, t, drv, "SELECT name FROM users", []any{"a8m"})
+
+		if err := drv.InvalidateTags(ctx, "tenant:acme"); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery("SELECT id FROM users WHERE tenant = 'acme'").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectQuery(tenant, t, drv, "SELECT id FROM users WHERE tenant = 'acme'", []any{int64(1)})
+		expectQuery(user, t, drv, "SELECT name FROM users WHERE id = 1", []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Promote", func(t *testing.T) {
+		// The first level holds a single entry, so the tagged
+		// entry is evicted from it, and promoted on the next hit.
+		drv := entcache.NewDriver(drv, entcache.Levels(entcache.NewLRU(1), entcache.NewLRU(0)))
+		ctx := context.Background()
+		user := entcache.Cache(ctx, entcache.WithTags("user:1"))
+		plain := entcache.Cache(ctx)
+		mock.ExpectQuery("SELECT name FROM users WHERE id = 1").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(user, t, drv, "SELECT name FROM users WHERE id = 1", []any{"a8m"})
+		expectQuery(plain, t, drv, "SELECT name FROM users", []any{"a8m"})
+		expectQuery(user, t, drv, "SELECT name FROM users WHERE id = 1", []any{"a8m"})
+
+		// The promoted entry is invalidated in the first level too.
+		if err := drv.InvalidateTags(ctx, "user:1"); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery("SELECT name FROM users WHERE id = 1").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(user, t, drv, "SELECT name FROM users WHERE id = 1", []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.Levels(unsupported{}))
+		if err := drv.InvalidateTags(context.Background(), "user:1"); err == nil {
+			t.Fatal("expected error for cache without tags support")
+		}
+	})
+}
+
+func TestRedis_Tags(t *testing.T) {
+	var (
+		ctx = context.Background()
+		rdb = ruemock.NewClient(gomock.NewController(t))
+		r   = entcache.NewRedis(rdb)
+	)
+	rdb.EXPECT().DoMulti(ctx,
+		ruemock.Match("SADD", "entcache:tag:user:1", "1"),
+		ruemock.Match("EXPIRE", "entcache:tag:user:1", "61", "NX"),
+		ruemock.Match("EXPIRE", "entcache:tag:user:1", "61", "GT"),
+	).Return([]rueidis.RedisResult{
+		ruemock.Result(ruemock.RedisInt64(1)),
+		ruemock.Result(ruemock.RedisInt64(1)),
+		ruemock.Result(ruemock.RedisInt64(0)),
+	})
+	if err := r.Tag(ctx, 1, []string{"user:1"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	rdb.EXPECT().Do(ctx, ruemock.Match("SMEMBERS", "entcache:tag:user:1")).
+		Return(ruemock.Result(ruemock.RedisArray(ruemock.RedisString("1"), ruemock.RedisString("2"))))
+	rdb.EXPECT().DoMulti(ctx,
+		ruemock.Match("DEL", "1", "entcache:lease:{1}"),
+		ruemock.Match("DEL", "2", "entcache:lease:{2}"),
+		ruemock.Match("SREM", "entcache:tag:user:1", "1", "2"),
+	).Return([]rueidis.RedisResult{
+		ruemock.Result(ruemock.RedisInt64(1)),
+		ruemock.Result(ruemock.RedisInt64(0)),
+		ruemock.Result(ruemock.RedisInt64(2)),
+	})
+	if err := r.InvalidateTags(ctx, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// unsupported is a cache level without support for optional interfaces.
+type unsupported struct{}
+
+func (unsupported) Add(context.Context, entcache.Key, *entcache.Entry, time.Duration) error {
+	return nil
+}
+
+func (unsupported) Get(context.Context, entcache.Key) (*entcache.Entry, error) {
+	return nil, entcache.ErrNotFound
+}
+
+func (unsupported) Del(context.Context, entcache.Key) error {
+	return nil
+}
@@ -0,0 +1,189 @@
+package entcache
+
+import (
+	"context"
+	stdsql "database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+)
+
+// Tx starts and returns a new cache-aware transaction.
+// See the txDriver documentation for more info.
+func (d *Driver) Tx(ctx context.Context) (dialect.Tx, error) {
+	tx, err := d.Driver.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &txDriver{Tx: tx, drv: d, ctx: ctx}, nil
+}
+
+// BeginTx calls BeginTx of the underlying driver, or fails if it is not supported,
+// and returns a cache-aware transaction. See the txDriver documentation for more info.
+func (d *Driver) BeginTx(ctx context.Context, opts *stdsql.TxOptions) (dialect.Tx, error) {
+	drv, ok := d.Driver.(interface {
+		BeginTx(context.Context, *stdsql.TxOptions) (dialect.Tx, error)
+	})
+	if !ok {
+		return nil, errors.New("Driver.BeginTx is not supported")
+	}
+	tx, err := drv.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &txDriver{Tx: tx, drv: d, ctx: ctx}, nil
+}
+
+// txDriver wraps a dialect.Tx with the caching layer of the Driver.
+//
+// Statements that modify tables are recorded, and the invalidation
+// they cause (see WithTableInvalidation) is applied only after the
+// transaction was committed successfully, or discarded on rollback.
+//
+// If TxCache is enabled, queries may be served from the cache, unless
+// they read from a table that was modified by the transaction. Results
+// of queries that are executed inside a transaction are never stored in
+// the cache, as they may reflect uncommitted changes or an old snapshot.
+type txDriver struct {
+	dialect.Tx
+	drv *Driver
+	ctx context.Context
+	// modified tables.
+	mu     sync.Mutex
+	tables []string
+}
+
+// Exec executes the statement in the transaction and records the tables it modifies.
+func (tx *txDriver) Exec(ctx context.Context, query string, args, v any) error {
+	if err := tx.Tx.Exec(ctx, query, args, v); err != nil {
+		return err
+	}
+	tx.record(query)
+	return nil
+}
+
+// Query executes the query in the transaction, or serves it from the cache if TxCache
+// is enabled and the query does not read tables that were modified by the transaction.
+func (tx *txDriver) Query(ctx context.Context, query string, args, v any) error {
+	if !cacheable(query) {
+		if err := tx.Tx.Query(ctx, query, args, v); err != nil {
+			return err
+		}
+		tx.record(query)
+		return nil
+	}
+	if !tx.drv.TxCache || tx.modified(readTables(query)) {
+		return tx.Tx.Query(ctx, query, args, v)
+	}
+	vr, ok := v.(*sql.Rows)
+	if !ok {
+		return fmt.Errorf("entcache: invalid type %T. expect *sql.Rows", v)
+	}
+	argv, ok := args.([]any)
+	if !ok {
+		return fmt.Errorf("entcache: invalid type %T. expect []any for args", args)
+	}
+	opts, err := tx.drv.optionsFromContext(ctx, query, argv)
+	if err != nil {
+		return tx.Tx.Query(ctx, query, args, v)
+	}
+	atomic.AddUint64(&tx.drv.stats.Gets, 1)
+	switch e, err := tx.drv.Cache.Get(ctx, opts.key); {
+	case err == nil:
+		atomic.AddUint64(&tx.drv.stats.Hits, 1)
+		vr.ColumnScanner = &repeater{columns: e.Columns, values: e.Values}
+		return nil
+	case errors.Is(err, ErrNotFound) && opts.cacheOnly:
+		vr.ColumnScanner = &repeater{columns: cacheOnlySentinelColumns, values: nil}
+		return nil
+	default:
+		return tx.Tx.Query(ctx, query, args, v)
+	}
+}
+
+// ExecContext calls ExecContext of the underlying transaction, or fails if it is not supported.
+func (tx *txDriver) ExecContext(ctx context.Context, query string, args ...any) (stdsql.Result, error) {
+	t, ok := tx.Tx.(interface {
+		ExecContext(context.Context, string, ...any) (stdsql.Result, error)
+	})
+	if !ok {
+		return nil, errors.New("Tx.ExecContext is not supported")
+	}
+	res, err := t.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	tx.record(query)
+	return res, nil
+}
+
+// QueryContext calls QueryContext of the underlying transaction, or fails if it is not supported.
+// Note, this method is not part of the caching layer since Ent does not use it by default.
+func (tx *txDriver) QueryContext(ctx context.Context, query string, args ...any) (*stdsql.Rows, error) {
+	t, ok := tx.Tx.(interface {
+		QueryContext(context.Context, string, ...any) (*stdsql.Rows, error)
+	})
+	if !ok {
+		return nil, errors.New("Tx.QueryContext is not supported")
+	}
+	rows, err := t.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if !cacheable(query) {
+		tx.record(query)
+	}
+	return rows, nil
+}
+
+// Commit commits the underlying transaction, and applies the invalidations
+// caused by the statements that were executed in it.
+func (tx *txDriver) Commit() error {
+	if err := tx.Tx.Commit(); err != nil {
+		return err
+	}
+	tx.mu.Lock()
+	tables := tx.tables
+	tx.tables = nil
+	tx.mu.Unlock()
+	if tx.drv.TableInvalidation {
+		tx.drv.invalidateTables(tx.ctx, tables)
+	}
+	return nil
+}
+
+// Rollback rolls back the underlying transaction,
+// and discards the recorded invalidations.
+func (tx *txDriver) Rollback() error {
+	tx.mu.Lock()
+	tx.tables = nil
+	tx.mu.Unlock()
+	return tx.Tx.Rollback()
+}
+
+// record records the tables modified by the given statement.
+func (tx *txDriver) record(query string) {
+	if tables := writeTables(query); len(tables) > 0 {
+		tx.mu.Lock()
+		tx.tables = appendUnique(tx.tables, tables...)
+		tx.mu.Unlock()
+	}
+}
+
+// modified reports if one of the given tables was modified by the transaction.
+func (tx *txDriver) modified(tables []string) bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for _, t := range tables {
+		for _, m := range tx.tables {
+			if t == m {
+				return true
+			}
+		}
+	}
+	return false
+}
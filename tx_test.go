@@ -0,0 +1,134 @@
+package entcache_test
+
+import (
+	"context"
+	"testing"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestDriver_Tx(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := sql.OpenDB(dialect.Postgres, db)
+	const (
+		users  = `SELECT "name" FROM "users"`
+		update = `UPDATE "users" SET "name" = $1`
+	)
+
+	t.Run("Commit", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.WithTableInvalidation(true))
+		ctx := entcache.Cache(context.Background())
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+
+		mock.ExpectBegin()
+		tx, err := drv.Tx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := tx.Exec(ctx, update, []any{"a8m"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		// Invalidation is deferred until commit.
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		mock.ExpectCommit()
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.WithTableInvalidation(true))
+		ctx := entcache.Cache(context.Background())
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+
+		mock.ExpectBegin()
+		tx, err := drv.Tx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := tx.Exec(ctx, update, []any{"a8m"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectRollback()
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("TxCache", func(t *testing.T) {
+		drv := entcache.NewDriver(drv, entcache.WithTableInvalidation(true), entcache.WithTxCache(true))
+		ctx := entcache.Cache(context.Background())
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+
+		mock.ExpectBegin()
+		tx, err := drv.Tx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Served from the cache.
+		expectQuery(ctx, t, tx, users, []any{"a8m"})
+		mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := tx.Exec(ctx, update, []any{"nat"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		// Tables modified by the transaction bypass the cache.
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("nat"))
+		expectQuery(ctx, t, tx, users, []any{"nat"})
+		mock.ExpectCommit()
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("nat"))
+		expectQuery(ctx, t, drv, users, []any{"nat"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		expected := entcache.Stats{Gets: 3, Hits: 1}
+		if s := drv.Stats(); s != expected {
+			t.Errorf("unexpected stats: %v != %v", s, expected)
+		}
+	})
+
+	t.Run("NoTxCache", func(t *testing.T) {
+		drv := entcache.NewDriver(drv)
+		ctx := entcache.Cache(context.Background())
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, users, []any{"a8m"})
+
+		mock.ExpectBegin()
+		tx, err := drv.Tx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectQuery(users).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, tx, users, []any{"a8m"})
+		mock.ExpectCommit()
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package entcache
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"golang.org/x/sync/errgroup"
+)
+
+// WarmupQuery is a query that is executed by Driver.Warmup for populating
+// the cache. Queries that are recorded by Driver.HotQueries hold the cache
+// key of the query, which is used instead of hashing the query again, as the
+// arguments may be decoded with different types (e.g., by encoding/json).
+// However, encoding/gob preserves their types, and is recommended for storing
+// the recorded queries between runs.
+type WarmupQuery struct {
+	Query string
+	Args  []any
+	// Key and TTL of the entry. Optional.
+	Key Key
+	TTL time.Duration
+}
+
+// DefaultWarmupConcurrency is the default value of Options.WarmupConcurrency.
+const DefaultWarmupConcurrency = 8
+
+// WarmupConcurrency configures the maximum number of
+// queries that are executed concurrently by Driver.Warmup.
+func WarmupConcurrency(n int) Option {
+	return func(o *Options) {
+		o.WarmupConcurrency = n
+	}
+}
+
+// RecordHotQueries configures the driver to record the n most frequently
+// executed cached queries, which are returned by Driver.HotQueries. Queries
+// are counted using the space-saving algorithm, and hence, the memory used
+// for recording them is bounded, and the returned counts are approximate.
+//
+//	drv := entcache.NewDriver(db, entcache.RecordHotQueries(100))
+//	// On shutdown.
+//	err := gob.NewEncoder(f).Encode(drv.HotQueries())
+//	// On boot.
+//	var queries []entcache.WarmupQuery
+//	if err := gob.NewDecoder(f).Decode(&queries); err == nil {
+//		err = drv.Warmup(ctx, queries)
+//	}
+func RecordHotQueries(n int) Option {
+	return func(o *Options) {
+		o.HotQueries = n
+	}
+}
+
+// Warmup executes the given queries concurrently, and stores their results in
+// all cache levels, overwriting existing entries (see Refresh). The number of
+// concurrent queries is bounded by WarmupConcurrency. Failed queries do not stop
+// the warmup, and their errors are joined and returned when all queries are done.
+func (d *Driver) Warmup(ctx context.Context, queries []WarmupQuery) error {
+	var (
+		g    errgroup.Group
+		mu   sync.Mutex
+		errs []error
+	)
+	g.SetLimit(max(d.WarmupConcurrency, 1))
+	for _, q := range queries {
+		if ctx.Err() != nil {
+			break
+		}
+		opts := []QueryOption{Refresh()}
+		if q.Key != nil {
+			opts = append(opts, WithKey(q.Key))
+		}
+		if q.TTL != 0 {
+			opts = append(opts, WithTTL(q.TTL))
+		}
+		g.Go(func() error {
+			if err := d.Query(Cache(ctx, opts...), q.Query, q.Args, &sql.Rows{}); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("entcache: warming up query %q: %w", q.Query, err))
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// HotQueries returns the most frequently executed cached queries, ordered
+// by their frequency, if recording is enabled (see RecordHotQueries).
+func (d *Driver) HotQueries() []WarmupQuery {
+	return d.hot.top()
+}
+
+// hotQueries records the most frequently executed queries using the
+// space-saving algorithm: it keeps a bounded number of counters, and when
+// a new query is recorded while they are full, it replaces the query with
+// the lowest count, and inherits its count. The counters are kept in a
+// min-heap, so the query with the lowest count is found in O(log n).
+type hotQueries struct {
+	n     int
+	mu    sync.Mutex
+	items map[Key]*hotQuery
+	heap  hotHeap
+}
+
+// hotQuery is a counted query.
+type hotQuery struct {
+	WarmupQuery
+	count uint64
+	// index of the query in the heap.
+	index int
+}
+
+// hotQueriesFactor is the number of counters that are kept per recorded
+// query, for improving the accuracy of the less frequent ones.
+const hotQueriesFactor = 8
+
+// record counts the execution of the given query.
+func (h *hotQueries) record(query string, args []any, opts ctxOptions) {
+	if h.n <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if q, ok := h.items[opts.key]; ok {
+		q.count++
+		heap.Fix(&h.heap, q.index)
+		return
+	}
+	if h.items == nil {
+		h.items = make(map[Key]*hotQuery)
+	}
+	var count uint64
+	if len(h.items) >= h.n*hotQueriesFactor {
+		q := heap.Pop(&h.heap).(*hotQuery)
+		delete(h.items, q.Key)
+		count = q.count
+	}
+	q := &hotQuery{
+		WarmupQuery: WarmupQuery{Query: query, Args: args, Key: opts.key, TTL: opts.ttl},
+		count:       count + 1,
+	}
+	h.items[opts.key] = q
+	heap.Push(&h.heap, q)
+}
+
+// hotHeap is a min-heap of queries ordered by their counts,
+// and implements the heap.Interface.
+type hotHeap []*hotQuery
+
+func (h hotHeap) Len() int           { return len(h) }
+func (h hotHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h hotHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *hotHeap) Push(x any) {
+	q := x.(*hotQuery)
+	q.index = len(*h)
+	*h = append(*h, q)
+}
+
+func (h *hotHeap) Pop() any {
+	old := *h
+	q := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return q
+}
+
+// top returns the n queries with the highest counts.
+func (h *hotQueries) top() []WarmupQuery {
+	h.mu.Lock()
+	items := make([]hotQuery, 0, len(h.items))
+	for _, q := range h.items {
+		items = append(items, *q)
+	}
+	h.mu.Unlock()
+	slices.SortFunc(items, func(a, b hotQuery) int {
+		return cmp.Compare(b.count, a.count)
+	})
+	queries := make([]WarmupQuery, 0, min(len(items), h.n))
+	for _, q := range items[:min(len(items), h.n)] {
+		queries = append(queries, q.WarmupQuery)
+	}
+	return queries
+}
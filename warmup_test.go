@@ -0,0 +1,140 @@
+package entcache_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestDriver_Warmup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	levels := []entcache.AddGetDeleter{entcache.NewLRU(0), entcache.NewLRU(0)}
+	drv := entcache.NewDriver(
+		sql.OpenDB(dialect.MySQL, db),
+		entcache.Levels(levels...),
+		entcache.WarmupConcurrency(2),
+		entcache.Hash(func(query string, _ []any) (entcache.Key, error) {
+			return query, nil
+		}),
+	)
+	queries := []entcache.WarmupQuery{
+		{Query: "SELECT name FROM users"},
+		{Query: "SELECT name FROM pets"},
+		{Query: "SELECT name FROM groups"},
+	}
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	mock.ExpectQuery("SELECT name FROM pets").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("pedro"))
+	mock.ExpectQuery("SELECT name FROM groups").
+		WillReturnError(errors.New("connection reset"))
+	err = drv.Warmup(context.Background(), queries)
+	if err == nil || !strings.Contains(err.Error(), "SELECT name FROM groups") {
+		t.Fatalf("expected warmup error, got: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range levels {
+		for _, k := range []string{"SELECT name FROM users", "SELECT name FROM pets"} {
+			if _, err := l.Get(context.Background(), k); err != nil {
+				t.Fatalf("expected %q in level %d: %v", k, i, err)
+			}
+		}
+	}
+	if s := drv.Stats(); s.Gets != 0 {
+		t.Fatalf("unexpected stats: %v", s)
+	}
+}
+
+func TestDriver_HotQueries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := entcache.NewDriver(sql.OpenDB(dialect.MySQL, db), entcache.RecordHotQueries(2))
+	ctx := entcache.Cache(context.Background())
+	for i, query := range []string{"SELECT name FROM users", "SELECT name FROM pets", "SELECT name FROM groups"} {
+		mock.ExpectQuery(query).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		for range 3 - i {
+			expectQuery(ctx, t, drv, query, []any{"a8m"})
+		}
+	}
+	hot := drv.HotQueries()
+	if len(hot) != 2 || hot[0].Query != "SELECT name FROM users" || hot[1].Query != "SELECT name FROM pets" {
+		t.Fatalf("unexpected hot queries: %v", hot)
+	}
+
+	// Recorded queries are replayed on the next boot.
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(hot); err != nil {
+		t.Fatal(err)
+	}
+	var queries []entcache.WarmupQuery
+	if err := gob.NewDecoder(&buf).Decode(&queries); err != nil {
+		t.Fatal(err)
+	}
+	drv = entcache.NewDriver(sql.OpenDB(dialect.MySQL, db))
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	mock.ExpectQuery("SELECT name FROM pets").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+	if err := drv.Warmup(context.Background(), queries); err != nil {
+		t.Fatal(err)
+	}
+	expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+	expectQuery(ctx, t, drv, "SELECT name FROM pets", []any{"a8m"})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if s := drv.Stats(); s.Hits != 2 {
+		t.Fatalf("unexpected stats: %v", s)
+	}
+}
+
+func TestDriver_HotQueriesEviction(t *testing.T) {
+	drv := entcache.NewDriver(nil,
+		entcache.RecordHotQueries(2),
+		entcache.Hash(func(query string, _ []any) (entcache.Key, error) {
+			return query, nil
+		}),
+	)
+	ctx := entcache.Cache(context.Background())
+	hit := func(query string) {
+		e := &entcache.Entry{Columns: []string{"name"}, Values: [][]driver.Value{{"a8m"}}}
+		if err := drv.Cache.Add(ctx, query, e, 0); err != nil {
+			t.Fatal(err)
+		}
+		expectQuery(ctx, t, drv, query, []any{"a8m"})
+	}
+	// The counters of the infrequent queries are replaced, while
+	// the counters of the frequent ones are kept.
+	for i := range 100 {
+		hit("SELECT name FROM users")
+		if i%2 == 0 {
+			hit("SELECT name FROM pets")
+		}
+		hit(fmt.Sprintf("SELECT name FROM groups WHERE id = %d", i))
+	}
+	hot := drv.HotQueries()
+	if len(hot) != 2 || hot[0].Query != "SELECT name FROM users" || hot[1].Query != "SELECT name FROM pets" {
+		t.Fatalf("unexpected hot queries: %v", hot)
+	}
+}
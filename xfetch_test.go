@@ -0,0 +1,117 @@
+package entcache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/DeltaLaboratory/entcache"
+)
+
+func TestDriver_XFetch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := entcache.Cache(context.Background())
+	newDriver := func(beta float64) *entcache.Driver {
+		return entcache.NewDriver(
+			sql.OpenDB(dialect.MySQL, db),
+			entcache.TTL(time.Hour),
+			entcache.XFetch(beta),
+			entcache.Hash(func(string, []any) (entcache.Key, error) {
+				return 1, nil
+			}),
+		)
+	}
+
+	t.Run("Record", func(t *testing.T) {
+		drv := newDriver(1)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillDelayFor(10 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		e, err := drv.Cache.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.CreatedAt.IsZero() || e.Delta < 10*time.Millisecond {
+			t.Fatalf("unexpected metadata: %v %v", e.CreatedAt, e.Delta)
+		}
+		// The entry is far from its expiry.
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if s := drv.Stats(); s.Early != 0 {
+			t.Errorf("unexpected stats: %v", s)
+		}
+	})
+
+	t.Run("Early", func(t *testing.T) {
+		// A large beta recomputes the entry long before it expires.
+		drv := newDriver(1e12)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillDelayFor(10 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("nati"))
+		// The current entry is served while it is recomputed.
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+			if e, err := drv.Cache.Get(ctx, 1); err == nil && e.Values[0][0] == "nati" {
+				break
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		if s := drv.Stats(); s.Early != 1 {
+			t.Errorf("unexpected stats: %v", s)
+		}
+	})
+
+	t.Run("SlowReader", func(t *testing.T) {
+		drv := newDriver(1)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		rows := &sql.Rows{}
+		if err := drv.Query(ctx, "SELECT name FROM users", []any{}, rows); err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		// The time the caller spends before closing the
+		// rows is not recorded as the compute duration.
+		time.Sleep(50 * time.Millisecond)
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+		e, err := drv.Cache.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Delta <= 0 || e.Delta >= 50*time.Millisecond {
+			t.Fatalf("unexpected compute duration: %v", e.Delta)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		drv := newDriver(0)
+		mock.ExpectQuery("SELECT name FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a8m"))
+		expectQuery(ctx, t, drv, "SELECT name FROM users", []any{"a8m"})
+		e, err := drv.Cache.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !e.CreatedAt.IsZero() || e.Delta != 0 {
+			t.Fatalf("unexpected metadata: %v %v", e.CreatedAt, e.Delta)
+		}
+	})
+}